@@ -0,0 +1,152 @@
+package initial
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"golang.org/x/sync/errgroup"
+
+	"github.com/go-dev-frame/sponge/pkg/app"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/prof"
+
+	"thrust_oauth2id/internal/upstream"
+)
+
+// reloader is implemented by servers that can restart without downtime.
+type reloader interface {
+	ReloadSignals() []os.Signal
+	Reload(ctx context.Context) (*upstream.ReloadResult, error)
+}
+
+// relayer is implemented by servers that forward signals to a child process.
+type relayer interface {
+	RelaySignals() []os.Signal
+	Signal(sig syscall.Signal) error
+}
+
+// App runs the servers like app.App, except that signals claimed by a reloader
+// trigger a reload and signals claimed by a relayer are forwarded, instead of
+// shutting the process down.
+type App struct {
+	servers []app.IServer
+	closes  []app.Close
+}
+
+// NewApp create an app
+func NewApp(servers []app.IServer, closes []app.Close) *App {
+	return &App{
+		servers: servers,
+		closes:  closes,
+	}
+}
+
+// Run servers
+func (a *App) Run() {
+	// ctx will be notified whenever an error occurs in one of the goroutines
+	eg, ctx := errgroup.WithContext(context.Background())
+
+	// start all servers
+	for _, server := range a.servers {
+		s := server
+		eg.Go(func() error {
+			fmt.Println(s.String())
+			return s.Start()
+		})
+	}
+
+	// watch and stop app
+	eg.Go(func() error {
+		return a.watch(ctx)
+	})
+
+	if err := eg.Wait(); err != nil {
+		panic(err)
+	}
+}
+
+// watch the os signal and the ctx signal from the errgroup, and stop the service if either signal is triggered
+func (a *App) watch(ctx context.Context) error {
+	reloaders := map[os.Signal][]reloader{}
+	signals := []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGTRAP}
+	relayers := map[os.Signal][]relayer{}
+	for _, server := range a.servers {
+		if r, ok := server.(reloader); ok {
+			for _, sig := range r.ReloadSignals() {
+				reloaders[sig] = append(reloaders[sig], r)
+				signals = append(signals, sig)
+			}
+		}
+		if r, ok := server.(relayer); ok {
+			for _, sig := range r.RelaySignals() {
+				relayers[sig] = append(relayers[sig], r)
+				signals = append(signals, sig)
+			}
+		}
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, signals...)
+	profile := prof.NewProfile()
+
+	for {
+		select {
+		case <-ctx.Done(): // service error
+			_ = a.stop()
+			return ctx.Err()
+
+		case sigType := <-sig: // system notification signal
+			fmt.Printf("received system notification signal: %s\n", sigType.String())
+			if rs, ok := reloaders[sigType]; ok {
+				for _, r := range rs {
+					go reload(r)
+				}
+				continue
+			}
+			if rs, ok := relayers[sigType]; ok {
+				for _, r := range rs {
+					if err := r.Signal(sigType.(syscall.Signal)); err != nil {
+						logger.Warn("relaying signal failed", logger.String("signal", sigType.String()), logger.Err(err))
+					}
+				}
+				continue
+			}
+
+			switch sigType {
+			case syscall.SIGTRAP:
+				profile.StartOrStop() // start or stop sampling profile
+			case syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP:
+				if err := a.stop(); err != nil {
+					return err
+				}
+				fmt.Println("stop app successfully")
+				return nil
+			}
+		}
+	}
+}
+
+func reload(r reloader) {
+	result, err := r.Reload(context.Background())
+	if err != nil {
+		logger.Warn("reload failed", logger.Err(err))
+		return
+	}
+	logger.Info("reload completed",
+		logger.Int("old_pid", result.OldPID),
+		logger.Int("new_pid", result.NewPID),
+		logger.Duration("duration", result.Duration))
+}
+
+// stopping services and releasing resources
+func (a *App) stop() error {
+	for _, closeFn := range a.closes {
+		if err := closeFn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -17,26 +17,65 @@ func CreateServices() []app.IServer {
 	var cfg = config.Get()
 	var servers []app.IServer
 
+	// create the upstream supervisors first so the reverse proxy can follow their lifecycle
+	upstreamCfgs, err := upstream.ResolveConfigs(cfg)
+	if err != nil {
+		logger.Fatal("invalid upstream configuration", logger.Err(err))
+	}
+
+	upstreamServers := make([]*upstream.Server, 0, len(upstreamCfgs))
+	for _, upstreamCfg := range upstreamCfgs {
+		upstreamServers = append(upstreamServers, upstream.NewServer(upstreamCfg))
+	}
+
 	// create a http service
 	httpServer := server.NewHTTPServer(cfg.HTTP,
 		server.WithHTTPIsProd(cfg.App.Env == "prod"),
+		server.WithHTTPUpstreams(upstreamServers...),
 	)
 	servers = append(servers, httpServer)
 
-	if cfg.Upstream.Enabled {
-		if cfg.Upstream.Command == "" {
-			logger.Fatal("upstream enabled but command not configured")
-		}
+	for _, upstreamServer := range upstreamServers {
+		servers = append(servers, upstreamServer)
+	}
+
+	return servers
+}
 
+// prepareUpstreams derives the upstream settings left to defaults and the
+// environment the upstreams start with. It runs before the configuration is
+// shown so both are part of it.
+func prepareUpstreams(cfg *config.Config) {
+	if cfg.Upstream.Enabled || cfg.Procfile != "" {
 		// If a unix socket is configured, do not derive or set TargetPort to avoid conflicts.
 		if cfg.Upstream.TargetBindSocket == "" && cfg.Upstream.TargetPort == 0 {
 			cfg.Upstream.TargetPort = deriveTargetPort(cfg.Proxy.TargetURL)
 		}
+	}
+
+	describeUpstreamEnv(cfg)
+}
 
-		servers = append(servers, upstream.NewServer(cfg.Upstream))
+// describeUpstreamEnv records the environment each upstream will start with, so
+// it is part of config.Show. Procfile processes share the base upstream's.
+func describeUpstreamEnv(cfg *config.Config) {
+	describe := func(u *config.Upstream) {
+		env, err := upstream.DescribeEnv(*u)
+		if err != nil {
+			logger.Warn("cannot resolve upstream environment", logger.String("name", u.Name), logger.Err(err))
+			return
+		}
+		u.ResolvedEnv = env
 	}
 
-	return servers
+	if cfg.Upstream.Enabled || cfg.Procfile != "" {
+		describe(&cfg.Upstream)
+	}
+	for i := range cfg.Upstreams {
+		if cfg.Upstreams[i].Enabled {
+			describe(&cfg.Upstreams[i])
+		}
+	}
 }
 
 func deriveTargetPort(rawURL string) int {
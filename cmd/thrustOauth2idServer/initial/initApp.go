@@ -42,6 +42,7 @@ func InitApp() {
 	if err != nil {
 		panic(err)
 	}
+	prepareUpstreams(cfg)
 	logger.Debug(config.Show())
 	logger.Info("[logger] was initialized")
 
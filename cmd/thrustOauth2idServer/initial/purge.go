@@ -0,0 +1,116 @@
+package initial
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"thrust_oauth2id/configs"
+	"thrust_oauth2id/internal/config"
+	"thrust_oauth2id/internal/types"
+)
+
+const purgeTimeout = 30 * time.Second
+
+// stringsFlag collects a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// Purge runs the purge subcommand against a running server's admin endpoint and
+// returns the process exit code, e.g.
+//
+//	thrustOauth2idServer purge -c configs/thrustOauth2idServer.yml --tag post-42 --tag user-7
+func Purge(args []string) int {
+	var (
+		form  types.PurgeCacheRequest
+		tags  stringsFlag
+		addr  string
+		token string
+	)
+
+	fs := flag.NewFlagSet("purge", flag.ContinueOnError)
+	fs.StringVar(&configFile, "c", "", "configuration file")
+	fs.StringVar(&form.URL, "url", "", "purge an exact url, or a path with query on any host")
+	fs.StringVar(&form.Prefix, "prefix", "", "purge request paths starting with this prefix")
+	fs.StringVar(&form.Host, "host", "", "purge responses for this host")
+	fs.Var(&tags, "tag", "purge responses tagged with Surrogate-Key or Cache-Tag (repeatable)")
+	fs.BoolVar(&form.All, "all", false, "purge everything")
+	fs.StringVar(&addr, "addr", "", "server address, default 127.0.0.1:<http.port>")
+	fs.StringVar(&token, "token", "", "admin token, default admin.token")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	form.Tags = tags
+
+	if configFile == "" {
+		configFile = configs.Location("thrustOauth2idServer.yml")
+	}
+	if err := config.Init(configFile); err != nil {
+		fmt.Fprintln(os.Stderr, "init config error:", err)
+		return 1
+	}
+	cfg := config.Get()
+	if addr == "" {
+		addr = "127.0.0.1:" + strconv.Itoa(cfg.HTTP.Port)
+	}
+	if token == "" {
+		token = cfg.Admin.Token
+	}
+
+	purged, err := requestPurge(addr, token, &form)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "purge failed:", err)
+		return 1
+	}
+	fmt.Printf("purged %d cached responses\n", purged)
+	return 0
+}
+
+func requestPurge(addr, token string, form *types.PurgeCacheRequest) (int, error) {
+	body, err := json.Marshal(form)
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+addr+"/admin/cache/purge", bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	client := &http.Client{Timeout: purgeTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close() //nolint
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	reply := &types.PurgeCacheReply{}
+	if err := json.Unmarshal(data, reply); err != nil {
+		return 0, fmt.Errorf("unexpected response (%s): %s", resp.Status, bytes.TrimSpace(data))
+	}
+	if resp.StatusCode != http.StatusOK || reply.Code != 0 {
+		return 0, fmt.Errorf("%s: %s", resp.Status, reply.Msg)
+	}
+	return reply.Data.Purged, nil
+}
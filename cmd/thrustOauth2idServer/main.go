@@ -2,7 +2,7 @@
 package main
 
 import (
-	"github.com/go-dev-frame/sponge/pkg/app"
+	"os"
 
 	"thrust_oauth2id/cmd/thrustOauth2idServer/initial"
 )
@@ -17,10 +17,14 @@ import (
 // @name Authorization
 // @description Type Bearer your-jwt-token to Value
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "purge" {
+		os.Exit(initial.Purge(os.Args[2:]))
+	}
+
 	initial.InitApp()
 	services := initial.CreateServices()
 	closes := initial.Close(services)
 
-	a := app.New(services, closes)
+	a := initial.NewApp(services, closes)
 	a.Run()
 }
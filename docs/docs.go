@@ -16,6 +16,215 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
+        "/admin/cache/keys": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists the cached responses matching every given criterion with their url, size, variant headers and remaining freshness, ordered by url. Without criteria every cached response is listed.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List cached responses",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "absolute url, or path with query matching any host",
+                        "name": "url",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "request path prefix",
+                        "name": "prefix",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "request host",
+                        "name": "host",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Surrogate-Key or Cache-Tag value",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "default": 100,
+                        "description": "maximum number of entries returned",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.ListCacheKeysReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/cache/purge": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Removes the cached responses matching every given criterion: an exact url, a path prefix, a host or any of the Surrogate-Key/Cache-Tag values sent by the upstream. all removes everything.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Remove cached responses",
+                "parameters": [
+                    {
+                        "description": "purge criteria",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.PurgeCacheRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.PurgeCacheReply"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/upstreams": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the name, pid, state and listen address of every supervised upstream process.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "List the supervised upstream processes",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/upstream.Status"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/upstreams/{name}/reload": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Starts a new upstream process, switches traffic to it once it is ready and stops the old one.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Phased restart of an upstream",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "upstream name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/upstream.ReloadResult"
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/upstreams/{name}/signal": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Delivers a named signal (e.g. USR1 for a puma phased restart, TTIN/TTOU to add or remove workers) to the upstream's process group.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Send a signal to an upstream",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "upstream name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "signal information",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/types.SignalUpstreamRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/types.Result"
+                        }
+                    }
+                }
+            }
+        },
         "/api/v1/users": {
             "post": {
                 "security": [
@@ -378,6 +587,37 @@ const docTemplate = `{
         }
     },
     "definitions": {
+        "types.CacheEntry": {
+            "type": "object",
+            "properties": {
+                "key": {
+                    "description": "cache key in hex",
+                    "type": "string"
+                },
+                "size": {
+                    "description": "stored size in bytes",
+                    "type": "integer"
+                },
+                "ttl": {
+                    "description": "seconds the response stays fresh, 0 once stale",
+                    "type": "integer"
+                },
+                "url": {
+                    "description": "request host, path and normalized query",
+                    "type": "string"
+                },
+                "variantHeader": {
+                    "description": "request headers named by Vary that select this variant",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "types.Column": {
             "type": "object",
             "properties": {
@@ -589,6 +829,36 @@ const docTemplate = `{
                 }
             }
         },
+        "types.ListCacheKeysReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "entries": {
+                            "description": "cached responses ordered by url",
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/types.CacheEntry"
+                            }
+                        },
+                        "total": {
+                            "description": "number of matching cached responses",
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
         "types.ListUserssByIDsReply": {
             "type": "object",
             "properties": {
@@ -676,6 +946,85 @@ const docTemplate = `{
                 }
             }
         },
+        "types.PurgeCacheReply": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data",
+                    "type": "object",
+                    "properties": {
+                        "purged": {
+                            "description": "number of cached responses removed",
+                            "type": "integer"
+                        }
+                    }
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.PurgeCacheRequest": {
+            "type": "object",
+            "properties": {
+                "all": {
+                    "description": "purge everything, other criteria are ignored",
+                    "type": "boolean"
+                },
+                "host": {
+                    "description": "request host such as \"example.com\"",
+                    "type": "string"
+                },
+                "prefix": {
+                    "description": "request path prefix such as \"/posts/\"",
+                    "type": "string"
+                },
+                "tags": {
+                    "description": "Surrogate-Key or Cache-Tag values such as \"post-42\"",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "url": {
+                    "description": "absolute url, or path with query matching any host",
+                    "type": "string"
+                }
+            }
+        },
+        "types.Result": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "return code",
+                    "type": "integer"
+                },
+                "data": {
+                    "description": "return data"
+                },
+                "msg": {
+                    "description": "return information description",
+                    "type": "string"
+                }
+            }
+        },
+        "types.SignalUpstreamRequest": {
+            "type": "object",
+            "required": [
+                "signal"
+            ],
+            "properties": {
+                "signal": {
+                    "description": "signal name such as \"USR1\", \"SIGTTIN\" or \"WINCH\"",
+                    "type": "string"
+                }
+            }
+        },
         "types.UpdateUsersByIDReply": {
             "type": "object",
             "properties": {
@@ -836,6 +1185,47 @@ const docTemplate = `{
                     "type": "string"
                 }
             }
+        },
+        "upstream.ReloadResult": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "duration": {
+                    "description": "nanoseconds",
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "newPid": {
+                    "type": "integer"
+                },
+                "oldPid": {
+                    "type": "integer"
+                }
+            }
+        },
+        "upstream.Status": {
+            "type": "object",
+            "properties": {
+                "address": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "pid": {
+                    "type": "integer"
+                },
+                "proxy": {
+                    "type": "boolean"
+                },
+                "state": {
+                    "type": "string"
+                }
+            }
         }
     },
     "securityDefinitions": {
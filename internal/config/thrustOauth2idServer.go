@@ -3,6 +3,8 @@
 package config
 
 import (
+	"encoding/json"
+
 	"github.com/go-dev-frame/sponge/pkg/conf"
 )
 
@@ -14,6 +16,8 @@ func Init(configFile string, fs ...func()) error {
 }
 
 func Show(hiddenFields ...string) string {
+	// admin.token grants access to /admin
+	hiddenFields = append(hiddenFields, `"token"`)
 	return conf.Show(config, hiddenFields...)
 }
 
@@ -29,15 +33,23 @@ func Set(conf *Config) {
 }
 
 type Config struct {
-	App      App      `yaml:"app" json:"app"`
-	Database Database `yaml:"database" json:"database"`
-	HTTP     HTTP     `yaml:"http" json:"http"`
-	Jaeger   Jaeger   `yaml:"jaeger" json:"jaeger"`
-	Logger   Logger   `yaml:"logger" json:"logger"`
-	Proxy    Proxy    `yaml:"proxy" json:"proxy"`
-	Rails    Rails    `yaml:"rails" json:"rails"`
-	Redis    Redis    `yaml:"redis" json:"redis"`
-	Upstream Upstream `yaml:"upstream" json:"upstream"`
+	Admin     Admin      `yaml:"admin" json:"admin"`
+	App       App        `yaml:"app" json:"app"`
+	Database  Database   `yaml:"database" json:"database"`
+	HTTP      HTTP       `yaml:"http" json:"http"`
+	Jaeger    Jaeger     `yaml:"jaeger" json:"jaeger"`
+	Logger    Logger     `yaml:"logger" json:"logger"`
+	Procfile  string     `yaml:"procfile" json:"procfile"`
+	Proxy     Proxy      `yaml:"proxy" json:"proxy"`
+	Rails     Rails      `yaml:"rails" json:"rails"`
+	Redis     Redis      `yaml:"redis" json:"redis"`
+	Upstream  Upstream   `yaml:"upstream" json:"upstream"`
+	Upstreams []Upstream `yaml:"upstreams" json:"upstreams"`
+}
+
+type Admin struct {
+	Enabled bool   `yaml:"enabled" json:"enabled"`
+	Token   string `yaml:"token" json:"token"`
 }
 
 type TLS struct {
@@ -67,24 +79,78 @@ type Jaeger struct {
 }
 
 type Upstream struct {
-	Args             []string `yaml:"args" json:"args"`
-	Command          string   `yaml:"command" json:"command"`
-	Enabled          bool     `yaml:"enabled" json:"enabled"`
-	Env              Env      `yaml:"env" json:"env"`
-	StopSignal       string   `yaml:"stopSignal" json:"stopSignal"`
-	TargetBindSocket string   `yaml:"targetBindSocket" json:"targetBindSocket"`
-	TargetPort       int      `yaml:"targetPort" json:"targetPort"`
-	WorkingDirectory string   `yaml:"workingDirectory" json:"workingDirectory"`
+	Args         []string   `yaml:"args" json:"args"`
+	Command      string     `yaml:"command" json:"command"`
+	Enabled      bool       `yaml:"enabled" json:"enabled"`
+	Env          Env        `yaml:"env" json:"env"`
+	EnvFiles     []string   `yaml:"envFiles" json:"envFiles"`
+	Group        string     `yaml:"group" json:"group"`
+	Hooks        Hooks      `yaml:"hooks" json:"hooks"`
+	InheritEnv   InheritEnv `yaml:"inheritEnv" json:"inheritEnv"`
+	Limits       Limits     `yaml:"limits" json:"limits"`
+	Name         string     `yaml:"name" json:"name"`
+	Output       Output     `yaml:"output" json:"output"`
+	PidFile      string     `yaml:"pidFile" json:"pidFile"`
+	Proxy        bool       `yaml:"proxy" json:"proxy"`
+	Readiness    Readiness  `yaml:"readiness" json:"readiness"`
+	RelaySignals []string   `yaml:"relaySignals" json:"relaySignals"`
+	Reload       Reload     `yaml:"reload" json:"reload"`
+	// ResolvedEnv is filled in at startup so config.Show lists the final
+	// environment of the upstream with its values redacted.
+	ResolvedEnv      map[string]string `yaml:"-" json:"resolvedEnv,omitempty"`
+	Restart          Restart           `yaml:"restart" json:"restart"`
+	SocketActivation bool              `yaml:"socketActivation" json:"socketActivation"`
+	StopSignal       string            `yaml:"stopSignal" json:"stopSignal"`
+	StopTimeout      int               `yaml:"stopTimeout" json:"stopTimeout"`
+	TargetBindSocket string            `yaml:"targetBindSocket" json:"targetBindSocket"`
+	TargetPort       int               `yaml:"targetPort" json:"targetPort"`
+	Umask            string            `yaml:"umask" json:"umask"`
+	User             string            `yaml:"user" json:"user"`
+	WorkingDirectory string            `yaml:"workingDirectory" json:"workingDirectory"`
 }
 
 type Proxy struct {
-	BadGatewayPage   string `yaml:"badGatewayPage" json:"badGatewayPage"`
-	Cache            Cache  `yaml:"cache" json:"cache"`
+	BadGatewayPage   string      `yaml:"badGatewayPage" json:"badGatewayPage"`
+	Cache            Cache       `yaml:"cache" json:"cache"`
+	Enabled          bool        `yaml:"enabled" json:"enabled"`
+	ForwardHeaders   bool        `yaml:"forwardHeaders" json:"forwardHeaders"`
+	H2cEnabled       bool        `yaml:"h2cEnabled" json:"h2cEnabled"`
+	HealthCheck      HealthCheck `yaml:"healthCheck" json:"healthCheck"`
+	Hold             Hold        `yaml:"hold" json:"hold"`
+	LoadBalancing    string      `yaml:"loadBalancing" json:"loadBalancing"`
+	Retry            Retry       `yaml:"retry" json:"retry"`
+	TargetURL        string      `yaml:"targetURL" json:"targetURL"`
+	Targets          []Target    `yaml:"targets" json:"targets"`
+	XSendfileEnabled bool        `yaml:"xSendfileEnabled" json:"xSendfileEnabled"`
+}
+
+type HealthCheck struct {
 	Enabled          bool   `yaml:"enabled" json:"enabled"`
-	ForwardHeaders   bool   `yaml:"forwardHeaders" json:"forwardHeaders"`
-	H2cEnabled       bool   `yaml:"h2cEnabled" json:"h2cEnabled"`
-	TargetURL        string `yaml:"targetURL" json:"targetURL"`
-	XSendfileEnabled bool   `yaml:"xSendfileEnabled" json:"xSendfileEnabled"`
+	FailureThreshold int    `yaml:"failureThreshold" json:"failureThreshold"`
+	Interval         int    `yaml:"interval" json:"interval"`
+	Passive          bool   `yaml:"passive" json:"passive"`
+	Path             string `yaml:"path" json:"path"`
+	SuccessThreshold int    `yaml:"successThreshold" json:"successThreshold"`
+	Timeout          int    `yaml:"timeout" json:"timeout"`
+}
+
+type Hold struct {
+	Enabled     bool `yaml:"enabled" json:"enabled"`
+	MaxRequests int  `yaml:"maxRequests" json:"maxRequests"`
+	MaxWait     int  `yaml:"maxWait" json:"maxWait"`
+}
+
+type Retry struct {
+	Backoff     int     `yaml:"backoff" json:"backoff"`
+	BudgetRatio float64 `yaml:"budgetRatio" json:"budgetRatio"`
+	Enabled     bool    `yaml:"enabled" json:"enabled"`
+	MaxBackoff  int     `yaml:"maxBackoff" json:"maxBackoff"`
+	MaxRetries  int     `yaml:"maxRetries" json:"maxRetries"`
+}
+
+type Target struct {
+	URL    string `yaml:"url" json:"url"`
+	Weight int    `yaml:"weight" json:"weight"`
 }
 
 type App struct {
@@ -103,10 +169,39 @@ type App struct {
 }
 
 type Cache struct {
-	CapacityBytes        int  `yaml:"capacityBytes" json:"capacityBytes"`
-	Enabled              bool `yaml:"enabled" json:"enabled"`
-	MaxItemSizeBytes     int  `yaml:"maxItemSizeBytes" json:"maxItemSizeBytes"`
-	MaxResponseBodyBytes int  `yaml:"maxResponseBodyBytes" json:"maxResponseBodyBytes"`
+	Backend              string     `yaml:"backend" json:"backend"`
+	CapacityBytes        int        `yaml:"capacityBytes" json:"capacityBytes"`
+	CollapseTimeout      int        `yaml:"collapseTimeout" json:"collapseTimeout"`
+	Directory            string     `yaml:"directory" json:"directory"`
+	Enabled              bool       `yaml:"enabled" json:"enabled"`
+	MaxItemSizeBytes     int        `yaml:"maxItemSizeBytes" json:"maxItemSizeBytes"`
+	MaxResponseBodyBytes int        `yaml:"maxResponseBodyBytes" json:"maxResponseBodyBytes"`
+	RangeFill            bool       `yaml:"rangeFill" json:"rangeFill"`
+	Redis                CacheRedis `yaml:"redis" json:"redis"`
+	RevalidationWindow   int        `yaml:"revalidationWindow" json:"revalidationWindow"`
+	Rules                CacheRules `yaml:"rules" json:"rules"`
+	StaleIfErrorTimeout  int        `yaml:"staleIfErrorTimeout" json:"staleIfErrorTimeout"`
+}
+
+type CacheRules struct {
+	BypassCookies []string        `yaml:"bypassCookies" json:"bypassCookies"`
+	BypassHeaders []string        `yaml:"bypassHeaders" json:"bypassHeaders"`
+	IgnoreQuery   []string        `yaml:"ignoreQuery" json:"ignoreQuery"`
+	KeyCookies    []string        `yaml:"keyCookies" json:"keyCookies"`
+	KeyHeaders    []string        `yaml:"keyHeaders" json:"keyHeaders"`
+	Paths         []CachePathRule `yaml:"paths" json:"paths"`
+}
+
+type CachePathRule struct {
+	NoCache bool   `yaml:"noCache" json:"noCache"`
+	Pattern string `yaml:"pattern" json:"pattern"`
+	TTL     int    `yaml:"ttl" json:"ttl"`
+}
+
+type CacheRedis struct {
+	KeyPrefix       string `yaml:"keyPrefix" json:"keyPrefix"`
+	L1CapacityBytes int    `yaml:"l1CapacityBytes" json:"l1CapacityBytes"`
+	L1TTL           int    `yaml:"l1TTL" json:"l1TTL"`
 }
 
 type Sqlite struct {
@@ -150,3 +245,61 @@ type Eab struct {
 // Any additional environment variable a deployer adds to the YAML (for example RAILS_LOG_TO_STDOUT or DATABASE_URL) will now be ignored by conf.Parse, so buildEnv() never sees it. Previously this worked without touching the Go code, which is critical for configuration.
 // This blocks operators from adding new env vars unless they rebuild the binary, so we need to keep Env as a map (or another dynamic representation).
 type Env map[string]string
+
+// MarshalJSON keeps the values, which often hold secrets, out of Show and /config.
+func (e Env) MarshalJSON() ([]byte, error) {
+	masked := make(map[string]string, len(e))
+	for key := range e {
+		masked[key] = "******"
+	}
+	return json.Marshal(masked)
+}
+
+type Hooks struct {
+	PostStop []Hook `yaml:"postStop" json:"postStop"`
+	PreStart []Hook `yaml:"preStart" json:"preStart"`
+}
+
+type Hook struct {
+	Args             []string `yaml:"args" json:"args"`
+	Command          string   `yaml:"command" json:"command"`
+	Env              Env      `yaml:"env" json:"env"`
+	Timeout          int      `yaml:"timeout" json:"timeout"`
+	WorkingDirectory string   `yaml:"workingDirectory" json:"workingDirectory"`
+}
+
+type InheritEnv struct {
+	Allow []string `yaml:"allow" json:"allow"`
+	Deny  []string `yaml:"deny" json:"deny"`
+}
+
+type Limits struct {
+	AddressSpace int64 `yaml:"addressSpace" json:"addressSpace"`
+	Core         int64 `yaml:"core" json:"core"`
+	NoFile       int64 `yaml:"noFile" json:"noFile"`
+}
+
+type Output struct {
+	Capture   bool `yaml:"capture" json:"capture"`
+	ParseJSON bool `yaml:"parseJson" json:"parseJson"`
+}
+
+type Readiness struct {
+	Path    string `yaml:"path" json:"path"`
+	Timeout int    `yaml:"timeout" json:"timeout"`
+}
+
+type Restart struct {
+	Backoff     int    `yaml:"backoff" json:"backoff"`
+	MaxBackoff  int    `yaml:"maxBackoff" json:"maxBackoff"`
+	MaxRestarts int    `yaml:"maxRestarts" json:"maxRestarts"`
+	Policy      string `yaml:"policy" json:"policy"`
+	Window      int    `yaml:"window" json:"window"`
+}
+
+type Reload struct {
+	AlternatePort   int      `yaml:"alternatePort" json:"alternatePort"`
+	AlternateSocket string   `yaml:"alternateSocket" json:"alternateSocket"`
+	Enabled         bool     `yaml:"enabled" json:"enabled"`
+	Signals         []string `yaml:"signals" json:"signals"`
+}
@@ -0,0 +1,18 @@
+package ecode
+
+import (
+	"github.com/go-dev-frame/sponge/pkg/errcode"
+)
+
+// cache business-level http error codes.
+// the cacheNO value range is 1~999, if the same error code is used, it will cause panic.
+var (
+	cacheNO       = 80
+	cacheName     = "proxy cache"
+	cacheBaseCode = errcode.HCode(cacheNO)
+
+	ErrPurgeCache    = errcode.NewError(cacheBaseCode+1, "failed to purge "+cacheName)
+	ErrListCacheKeys = errcode.NewError(cacheBaseCode+2, "failed to list "+cacheName+" keys")
+
+	// error codes are globally unique, adding 1 to the previous error code
+)
@@ -0,0 +1,18 @@
+package ecode
+
+import (
+	"github.com/go-dev-frame/sponge/pkg/errcode"
+)
+
+// upstream business-level http error codes.
+// the upstreamNO value range is 1~999, if the same error code is used, it will cause panic.
+var (
+	upstreamNO       = 79
+	upstreamName     = "upstream"
+	upstreamBaseCode = errcode.HCode(upstreamNO)
+
+	ErrReloadUpstream = errcode.NewError(upstreamBaseCode+1, "failed to reload "+upstreamName)
+	ErrSignalUpstream = errcode.NewError(upstreamBaseCode+2, "failed to signal "+upstreamName)
+
+	// error codes are globally unique, adding 1 to the previous error code
+)
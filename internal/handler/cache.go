@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+	"github.com/go-dev-frame/sponge/pkg/utils"
+
+	"thrust_oauth2id/internal/ecode"
+	proxcache "thrust_oauth2id/internal/proxy/cache"
+	"thrust_oauth2id/internal/types"
+)
+
+var _ CacheHandler = (*cacheHandler)(nil)
+
+// CacheHandler defining the handler interface
+type CacheHandler interface {
+	Purge(c *gin.Context)
+	Keys(c *gin.Context)
+}
+
+type cacheHandler struct {
+	cache *proxcache.CacheHandler
+}
+
+// NewCacheHandler creating the handler interface
+func NewCacheHandler(cache *proxcache.CacheHandler) CacheHandler {
+	return &cacheHandler{cache: cache}
+}
+
+// Purge remove cached responses
+// @Summary Remove cached responses
+// @Description Removes the cached responses matching every given criterion: an exact url, a path prefix, a host or any of the Surrogate-Key/Cache-Tag values sent by the upstream. all removes everything.
+// @Tags admin
+// @accept json
+// @Produce json
+// @Param data body types.PurgeCacheRequest true "purge criteria"
+// @Success 200 {object} types.PurgeCacheReply{}
+// @Router /admin/cache/purge [post]
+// @Security BearerAuth
+func (h *cacheHandler) Purge(c *gin.Context) {
+	form := &types.PurgeCacheRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	purged, err := h.cache.Purge(proxcache.PurgeFilter{
+		All:    form.All,
+		URL:    form.URL,
+		Prefix: form.Prefix,
+		Host:   form.Host,
+		Tags:   form.Tags,
+	})
+	if err != nil {
+		logger.Warn("Purge error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		if errors.Is(err, proxcache.ErrInvalidPurgeFilter) {
+			response.Error(c, ecode.InvalidParams.WithDetails(err.Error()))
+			return
+		}
+		response.Error(c, ecode.ErrPurgeCache.WithDetails(err.Error()))
+		return
+	}
+
+	response.Success(c, gin.H{"purged": purged})
+}
+
+// Keys list cached responses
+// @Summary List cached responses
+// @Description Lists the cached responses matching every given criterion with their url, size, variant headers and remaining freshness, ordered by url. Without criteria every cached response is listed.
+// @Tags admin
+// @Produce json
+// @Param url query string false "absolute url, or path with query matching any host"
+// @Param prefix query string false "request path prefix"
+// @Param host query string false "request host"
+// @Param tag query []string false "Surrogate-Key or Cache-Tag value" collectionFormat(multi)
+// @Param limit query int false "maximum number of entries returned" default(100)
+// @Success 200 {object} types.ListCacheKeysReply{}
+// @Router /admin/cache/keys [get]
+// @Security BearerAuth
+func (h *cacheHandler) Keys(c *gin.Context) {
+	limit := utils.StrToInt(c.Query("limit"))
+	if limit <= 0 {
+		limit = 100
+	}
+
+	entries, total, err := h.cache.Keys(proxcache.PurgeFilter{
+		URL:    c.Query("url"),
+		Prefix: c.Query("prefix"),
+		Host:   c.Query("host"),
+		Tags:   c.QueryArray("tag"),
+	}, limit)
+	if err != nil {
+		logger.Warn("Keys error", logger.Err(err), middleware.GCtxRequestIDField(c))
+		if errors.Is(err, proxcache.ErrInvalidPurgeFilter) {
+			response.Error(c, ecode.InvalidParams.WithDetails(err.Error()))
+			return
+		}
+		response.Error(c, ecode.ErrListCacheKeys.WithDetails(err.Error()))
+		return
+	}
+
+	data := make([]types.CacheEntry, 0, len(entries))
+	for _, entry := range entries {
+		data = append(data, types.CacheEntry{
+			Key:           strconv.FormatUint(uint64(entry.Key), 16),
+			URL:           entry.URL,
+			Size:          entry.Size,
+			VariantHeader: entry.VariantHeader,
+			TTL:           int64(entry.TTL.Seconds()),
+		})
+	}
+
+	response.Success(c, gin.H{"entries": data, "total": total})
+}
@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"context"
+	"errors"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/go-dev-frame/sponge/pkg/gin/middleware"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"thrust_oauth2id/internal/ecode"
+	"thrust_oauth2id/internal/types"
+	"thrust_oauth2id/internal/upstream"
+)
+
+var _ UpstreamHandler = (*upstreamHandler)(nil)
+
+// UpstreamHandler defining the handler interface
+type UpstreamHandler interface {
+	List(c *gin.Context)
+	Reload(c *gin.Context)
+	Signal(c *gin.Context)
+}
+
+type upstreamHandler struct {
+	servers []*upstream.Server
+}
+
+// NewUpstreamHandler creating the handler interface
+func NewUpstreamHandler(servers []*upstream.Server) UpstreamHandler {
+	return &upstreamHandler{servers: servers}
+}
+
+// List the supervised upstream processes
+// @Summary List the supervised upstream processes
+// @Description Returns the name, pid, state and listen address of every supervised upstream process.
+// @Tags admin
+// @Produce json
+// @Success 200 {object} []upstream.Status{}
+// @Router /admin/upstreams [get]
+// @Security BearerAuth
+func (h *upstreamHandler) List(c *gin.Context) {
+	statuses := make([]upstream.Status, 0, len(h.servers))
+	for _, s := range h.servers {
+		statuses = append(statuses, s.Status())
+	}
+
+	response.Success(c, statuses)
+}
+
+// Reload phased restart of an upstream
+// @Summary Phased restart of an upstream
+// @Description Starts a new upstream process, switches traffic to it once it is ready and stops the old one.
+// @Tags admin
+// @Produce json
+// @Param name path string true "upstream name"
+// @Success 200 {object} upstream.ReloadResult{}
+// @Router /admin/upstreams/{name}/reload [post]
+// @Security BearerAuth
+func (h *upstreamHandler) Reload(c *gin.Context) {
+	server := h.find(c.Param("name"))
+	if server == nil {
+		response.Error(c, ecode.NotFound)
+		return
+	}
+
+	// the reload must not be abandoned half way when the caller hangs up
+	ctx := context.WithoutCancel(c.Request.Context())
+	result, err := server.Reload(ctx)
+	if err != nil {
+		logger.Warn("Reload error", logger.Err(err), logger.String("name", server.Name()), middleware.GCtxRequestIDField(c))
+		if errors.Is(err, upstream.ErrReloadInProgress) {
+			response.Error(c, ecode.Conflict)
+			return
+		}
+		response.Error(c, ecode.ErrReloadUpstream.WithDetails(err.Error()))
+		return
+	}
+
+	response.Success(c, result)
+}
+
+// Signal send a signal to an upstream
+// @Summary Send a signal to an upstream
+// @Description Delivers a named signal (e.g. USR1 for a puma phased restart, TTIN/TTOU to add or remove workers) to the upstream's process group.
+// @Tags admin
+// @accept json
+// @Produce json
+// @Param name path string true "upstream name"
+// @Param data body types.SignalUpstreamRequest true "signal information"
+// @Success 200 {object} types.Result{}
+// @Router /admin/upstreams/{name}/signal [post]
+// @Security BearerAuth
+func (h *upstreamHandler) Signal(c *gin.Context) {
+	server := h.find(c.Param("name"))
+	if server == nil {
+		response.Error(c, ecode.NotFound)
+		return
+	}
+
+	form := &types.SignalUpstreamRequest{}
+	err := c.ShouldBindJSON(form)
+	if err != nil {
+		logger.Warn("ShouldBindJSON error: ", logger.Err(err), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.InvalidParams)
+		return
+	}
+
+	sig, err := upstream.ParseSignal(form.Signal)
+	if err != nil {
+		response.Error(c, ecode.InvalidParams.WithDetails(err.Error()))
+		return
+	}
+
+	err = server.Signal(sig)
+	if err != nil {
+		logger.Warn("Signal error", logger.Err(err), logger.String("name", server.Name()), middleware.GCtxRequestIDField(c))
+		response.Error(c, ecode.ErrSignalUpstream.WithDetails(err.Error()))
+		return
+	}
+
+	response.Success(c)
+}
+
+func (h *upstreamHandler) find(name string) *upstream.Server {
+	for _, s := range h.servers {
+		if s.Name() == name {
+			return s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package proxycache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDeltaSeconds caps delta-seconds values, RFC 9111 section 1.2.2.
+const maxDeltaSeconds = 1 << 31
+
+// cacheControl holds the directives of Cache-Control header fields, keyed by
+// lowercase name. Directives without an argument map to "".
+type cacheControl map[string]string
+
+// parseCacheControl parses every Cache-Control field of header as RFC 9111
+// section 5.2 describes. Quoted arguments may contain commas; when a directive
+// repeats the first occurrence wins.
+func parseCacheControl(header http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, field := range header.Values("Cache-Control") {
+		for len(field) > 0 {
+			var directive string
+			directive, field = nextDirective(field)
+
+			name, value, _ := strings.Cut(directive, "=")
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if _, seen := cc[name]; seen {
+				continue
+			}
+			cc[name] = unquoteDirective(strings.TrimSpace(value))
+		}
+	}
+	return cc
+}
+
+// requestCacheControl parses the request's directives, honouring the HTTP/1.0
+// Pragma: no-cache when no Cache-Control is sent.
+func requestCacheControl(r *http.Request) cacheControl {
+	cc := parseCacheControl(r.Header)
+	if len(r.Header.Values("Cache-Control")) == 0 && strings.Contains(strings.ToLower(r.Header.Get("Pragma")), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// seconds returns a delta-seconds argument, capped at maxDeltaSeconds.
+// Invalid values report false.
+func (cc cacheControl) seconds(name string) (time.Duration, bool) {
+	value, ok := cc[name]
+	if !ok || value == "" {
+		return 0, false
+	}
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n > maxDeltaSeconds {
+		n = maxDeltaSeconds
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// nextDirective splits off the first directive, skipping commas inside quotes.
+func nextDirective(s string) (string, string) {
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			if quoted {
+				i++
+			}
+		case '"':
+			quoted = !quoted
+		case ',':
+			if !quoted {
+				return s[:i], s[i+1:]
+			}
+		}
+	}
+	return s, ""
+}
+
+func unquoteDirective(value string) string {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return value
+	}
+	value = value[1 : len(value)-1]
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			i++
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
+
+// freshnessLifetime returns the lifetime a shared cache gives the response
+// (RFC 9111 section 4.2.1): s-maxage, then max-age, then Expires minus Date.
+// It reports false when the response carries none of them.
+func freshnessLifetime(header http.Header, cc cacheControl) (time.Duration, bool) {
+	if lifetime, ok := cc.seconds("s-maxage"); ok {
+		return lifetime, true
+	}
+	if lifetime, ok := cc.seconds("max-age"); ok {
+		return lifetime, true
+	}
+
+	expires := header.Get("Expires")
+	if expires == "" {
+		return 0, false
+	}
+	expiresAt, err := http.ParseTime(expires)
+	if err != nil {
+		// invalid dates, such as "0", mean already expired
+		return 0, true
+	}
+	date, err := http.ParseTime(header.Get("Date"))
+	if err != nil {
+		return 0, false
+	}
+	return max(expiresAt.Sub(date), 0), true
+}
+
+// correctedInitialAge implements the age calculation of RFC 9111 section
+// 4.2.3 at the time the response was received.
+func correctedInitialAge(header http.Header, requestTime, responseTime time.Time) time.Duration {
+	var apparentAge time.Duration
+	if date, err := http.ParseTime(header.Get("Date")); err == nil {
+		apparentAge = max(responseTime.Sub(date), 0)
+	}
+
+	var ageValue time.Duration
+	if age, err := strconv.ParseInt(strings.TrimSpace(header.Get("Age")), 10, 64); err == nil && age > 0 {
+		ageValue = time.Duration(min(age, maxDeltaSeconds)) * time.Second
+	}
+	responseDelay := max(responseTime.Sub(requestTime), 0)
+
+	return max(apparentAge, ageValue+responseDelay)
+}
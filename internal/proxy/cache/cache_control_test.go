@@ -0,0 +1,244 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCacheControl(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields []string
+		want   cacheControl
+	}{
+		{"empty", nil, cacheControl{}},
+		{"case and spaces", []string{" Public ,MAX-AGE=60 "}, cacheControl{"public": "", "max-age": "60"}},
+		{"several fields", []string{"public", "s-maxage=10"}, cacheControl{"public": "", "s-maxage": "10"}},
+		{"quoted comma", []string{`private="Set-Cookie, X-Token", max-age=5`}, cacheControl{"private": "Set-Cookie, X-Token", "max-age": "5"}},
+		{"quoted escape", []string{`ext="a\"b"`}, cacheControl{"ext": `a"b`}},
+		{"first wins", []string{"max-age=5, max-age=10"}, cacheControl{"max-age": "5"}},
+		{"empty directives", []string{",,no-store,"}, cacheControl{"no-store": ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			for _, field := range tt.fields {
+				header.Add("Cache-Control", field)
+			}
+			assert.Equal(t, tt.want, parseCacheControl(header))
+		})
+	}
+}
+
+func TestCacheControlSeconds(t *testing.T) {
+	cc := cacheControl{"a": "60", "b": "-1", "c": "1e3", "d": "", "e": "99999999999999999999", "f": `"30"`}
+
+	tests := []struct {
+		name string
+		want time.Duration
+		ok   bool
+	}{
+		{"a", time.Minute, true},
+		{"b", 0, false},
+		{"c", 0, false},
+		{"d", 0, false},
+		{"e", maxDeltaSeconds * time.Second, true},
+		{"f", 0, false},
+		{"missing", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := cc.seconds(tt.name)
+		assert.Equal(t, tt.ok, ok, tt.name)
+		assert.Equal(t, tt.want, got, tt.name)
+	}
+}
+
+// TestCacheStatusConformance covers the storage and freshness rules of RFC 9111
+// for a shared cache.
+func TestCacheStatusConformance(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	date := now.Format(http.TimeFormat)
+
+	tests := []struct {
+		name     string
+		status   int
+		header   map[string]string
+		storable bool
+		lifetime time.Duration
+	}{
+		{"max-age", 200, map[string]string{"Cache-Control": "max-age=60"}, true, time.Minute},
+		{"public max-age", 200, map[string]string{"Cache-Control": "public, max-age=60"}, true, time.Minute},
+		{"s-maxage wins over max-age", 200, map[string]string{"Cache-Control": "max-age=60, s-maxage=10"}, true, 10 * time.Second},
+		{"misspelt s-max-age ignored", 200, map[string]string{"Cache-Control": "max-age=60, s-max-age=10"}, true, time.Minute},
+		{"max-age wins over Expires", 200, map[string]string{"Cache-Control": "max-age=60", "Date": date, "Expires": now.Add(time.Hour).Format(http.TimeFormat)}, true, time.Minute},
+		{"Expires minus Date", 200, map[string]string{"Date": date, "Expires": now.Add(time.Hour).Format(http.TimeFormat)}, true, time.Hour},
+		{"invalid Expires is expired", 200, map[string]string{"Date": date, "Expires": "0", "Etag": `"x"`}, true, 0},
+		{"Expires in the past", 200, map[string]string{"Date": date, "Expires": now.Add(-time.Hour).Format(http.TimeFormat)}, false, 0},
+		{"Age reduces freshness", 200, map[string]string{"Cache-Control": "max-age=60", "Age": "20"}, true, 40 * time.Second},
+		{"Date in the past reduces freshness", 200, map[string]string{"Cache-Control": "max-age=60", "Date": now.Add(-30 * time.Second).Format(http.TimeFormat)}, true, 30 * time.Second},
+		{"no explicit lifetime", 200, map[string]string{"Cache-Control": "public"}, false, 0},
+		{"no-store", 200, map[string]string{"Cache-Control": "max-age=60, no-store"}, false, 0},
+		{"private", 200, map[string]string{"Cache-Control": "private, max-age=60"}, false, 0},
+		{"qualified private", 200, map[string]string{"Cache-Control": `private="Set-Cookie", max-age=60`}, false, 0},
+		{"no-cache with validator", 200, map[string]string{"Cache-Control": "no-cache, max-age=60", "Etag": `"x"`}, true, 0},
+		{"no-cache without validator", 200, map[string]string{"Cache-Control": "no-cache, max-age=60"}, false, 0},
+		{"max-age=0 without validator", 200, map[string]string{"Cache-Control": "max-age=0"}, false, 0},
+		{"max-age=0 with Last-Modified", 200, map[string]string{"Cache-Control": "max-age=0", "Last-Modified": date}, true, 0},
+		{"max-age=0 with stale-if-error", 200, map[string]string{"Cache-Control": "max-age=0, stale-if-error=60"}, true, 0},
+		{"invalid max-age", 200, map[string]string{"Cache-Control": "max-age=soon"}, false, 0},
+		{"Vary star", 200, map[string]string{"Cache-Control": "max-age=60", "Vary": "*"}, false, 0},
+		{"redirect", 301, map[string]string{"Cache-Control": "max-age=60"}, true, time.Minute},
+		{"not modified", 304, map[string]string{"Cache-Control": "max-age=60"}, false, 0},
+		{"server error", 500, map[string]string{"Cache-Control": "max-age=60"}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := CacheableResponse{StatusCode: tt.status, HttpHeader: http.Header{}, RequestTime: now, ResponseTime: now}
+			for k, v := range tt.header {
+				cr.HttpHeader.Set(k, v)
+			}
+
+			storable, expires := cr.CacheStatus()
+			assert.Equal(t, tt.storable, storable)
+			if tt.storable {
+				assert.Equal(t, tt.lifetime, expires.Sub(now))
+			}
+		})
+	}
+}
+
+func TestCacheableResponseStaleRules(t *testing.T) {
+	tests := []struct {
+		cc             string
+		swr, sie       time.Duration
+		mustRevalidate bool
+	}{
+		{"max-age=60, stale-while-revalidate=30, stale-if-error=600", 30 * time.Second, 10 * time.Minute, false},
+		{"max-age=60, stale-if-error=600, must-revalidate", 0, 0, true},
+		{"max-age=60, stale-while-revalidate=30, proxy-revalidate", 0, 0, true},
+		{"s-maxage=60, stale-while-revalidate=30", 0, 0, true},
+		{"no-cache, stale-while-revalidate=30", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		cr := CacheableResponse{HttpHeader: http.Header{"Cache-Control": {tt.cc}}}
+		swr, sie := cr.staleWindows()
+		assert.Equal(t, tt.swr, swr, tt.cc)
+		assert.Equal(t, tt.sie, sie, tt.cc)
+		assert.Equal(t, tt.mustRevalidate, cr.mustRevalidate(), tt.cc)
+	}
+}
+
+func TestCacheableResponseUsableFor(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	// received 30s ago with a 60s lifetime: age 30s, fresh for another 30s
+	fresh := CacheableResponse{HttpHeader: http.Header{}, ResponseTime: now.Add(-30 * time.Second), Expires: now.Add(30 * time.Second)}
+	// expired 30s ago
+	stale := CacheableResponse{HttpHeader: http.Header{}, ResponseTime: now.Add(-90 * time.Second), Expires: now.Add(-30 * time.Second)}
+	staleMustRevalidate := stale
+	staleMustRevalidate.HttpHeader = http.Header{"Cache-Control": {"max-age=60, must-revalidate"}}
+
+	tests := []struct {
+		name     string
+		response CacheableResponse
+		request  string
+		want     bool
+	}{
+		{"fresh", fresh, "", true},
+		{"no-cache", fresh, "no-cache", false},
+		{"max-age above age", fresh, "max-age=60", true},
+		{"max-age below age", fresh, "max-age=10", false},
+		{"max-age=0", fresh, "max-age=0", false},
+		{"min-fresh satisfied", fresh, "min-fresh=20", true},
+		{"min-fresh not satisfied", fresh, "min-fresh=40", false},
+		{"stale", stale, "", false},
+		{"max-stale unbounded", stale, "max-stale", true},
+		{"max-stale enough", stale, "max-stale=60", true},
+		{"max-stale too small", stale, "max-stale=10", false},
+		{"max-stale and must-revalidate", staleMustRevalidate, "max-stale", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			header := http.Header{}
+			if tt.request != "" {
+				header.Set("Cache-Control", tt.request)
+			}
+			assert.Equal(t, tt.want, tt.response.usableFor(parseCacheControl(header), now))
+		})
+	}
+}
+
+func TestCacheHandlerRequestDirectives(t *testing.T) {
+	var hits int
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Age", "5")
+		_, _ = w.Write([]byte("body"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+
+	send := func(header map[string]string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/page", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := send(map[string]string{"Cache-Control": "only-if-cached"})
+	assert.Equal(t, http.StatusGatewayTimeout, rr.Code)
+	assert.Equal(t, 0, hits)
+
+	rr = send(map[string]string{"Cache-Control": "no-store"})
+	require.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	rr = send(nil)
+	require.Equal(t, "miss", rr.Header().Get("X-Cache"), "no-store requests are not stored")
+
+	rr = send(nil)
+	assert.Equal(t, "hit", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "5", rr.Header().Get("Age"))
+
+	rr = send(map[string]string{"Cache-Control": "no-cache"})
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	rr = send(map[string]string{"Pragma": "no-cache"})
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	assert.Equal(t, 4, hits)
+}
+
+func TestCacheHandlerSharesAuthorizedResponsesOnlyWhenAllowed(t *testing.T) {
+	var hits int
+	cc := "max-age=60"
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Header().Set("Cache-Control", cc)
+		_, _ = w.Write([]byte("body"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+
+	authorized := func(path string) string {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com"+path, nil)
+		req.Header.Set("Authorization", "Bearer token")
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Header().Get("X-Cache")
+	}
+
+	authorized("/private")
+	assert.Equal(t, "miss", authorized("/private"))
+
+	cc = "public, max-age=60"
+	authorized("/public")
+	assert.Equal(t, "hit", authorized("/public"))
+	assert.Equal(t, 3, hits)
+}
@@ -15,6 +15,8 @@ type CacheKey uint64
 type Cache interface {
 	Get(key CacheKey) ([]byte, bool)
 	Set(key CacheKey, value []byte, expiresAt time.Time)
+	Delete(key CacheKey)
+	Clear()
 }
 
 // CacheHandler intercepts responses to add caching semantics around the next handler.
@@ -25,65 +27,242 @@ type CacheHandler struct {
 
 	varyIndexMu sync.RWMutex
 	varyIndex   map[CacheKey][]string
+
+	// revalidating holds the keys refreshed in the background.
+	revalidating        sync.Map
+	staleIfErrorTimeout time.Duration
+	revalidationWindow  time.Duration
+	rangeFill           bool
+	rules               Rules
+
+	flights         *flights
+	collapseTimeout time.Duration
+
+	stats *cacheStats
+}
+
+// HandlerOption configures a CacheHandler.
+type HandlerOption func(*CacheHandler)
+
+// WithStaleIfErrorTimeout bounds how long a request with a stale-if-error copy
+// waits for the upstream's response headers before the copy is served instead.
+// Zero waits as long as the upstream takes.
+func WithStaleIfErrorTimeout(d time.Duration) HandlerOption {
+	return func(h *CacheHandler) {
+		h.staleIfErrorTimeout = d
+	}
+}
+
+// WithRevalidationWindow sets how long an expired response carrying an ETag or
+// Last-Modified is kept to be revalidated with a conditional request.
+func WithRevalidationWindow(d time.Duration) HandlerOption {
+	return func(h *CacheHandler) {
+		if d > 0 {
+			h.revalidationWindow = d
+		}
+	}
+}
+
+// WithRangeFill makes a Range request that misses fetch the full response
+// from the upstream, cache it and answer the range from it. Without it such
+// requests are passed through uncached.
+func WithRangeFill(enabled bool) HandlerOption {
+	return func(h *CacheHandler) {
+		h.rangeFill = enabled
+	}
+}
+
+// WithCollapseTimeout bounds how long a request waits for another request
+// fetching the same response before it goes to the upstream itself.
+func WithCollapseTimeout(d time.Duration) HandlerOption {
+	return func(h *CacheHandler) {
+		if d > 0 {
+			h.collapseTimeout = d
+		}
+	}
 }
 
 // NewCacheHandler constructs a caching handler in front of the provided next handler.
-func NewCacheHandler(cache Cache, maxBodySize int, next http.Handler) *CacheHandler {
-	return &CacheHandler{
+func NewCacheHandler(cache Cache, maxBodySize int, next http.Handler, opts ...HandlerOption) *CacheHandler {
+	h := &CacheHandler{
 		cache:       cache,
 		next:        next,
 		maxBodySize: maxBodySize,
 		varyIndex:   make(map[CacheKey][]string),
+
+		revalidationWindow: defaultRevalidationWindow,
+
+		flights:         newFlights(),
+		collapseTimeout: defaultCollapseTimeout,
+
+		stats: newCacheStats(),
+	}
+	for _, opt := range opts {
+		opt(h)
 	}
+	return h
 }
 
 // ServeHTTP attempts to serve a cached response, falling back to the next handler.
 func (h *CacheHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	variant := NewVariant(r)
+	h.serve(w, r)
+	// every path through serve labels the response with its X-Cache result
+	h.stats.record(r.Host, w.Header().Get("X-Cache"))
+}
+
+// Private
+
+func (h *CacheHandler) serve(w http.ResponseWriter, r *http.Request) {
+	if reason := h.rules.bypassReason(r); reason != "" {
+		logger.Debug("proxy cache: bypassing request", logger.String("path", r.URL.Path), logger.String("rule", reason))
+		w.Header().Set("X-Cache", cacheStatusBypass)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	variant := newVariant(r, &h.rules)
 	baseKey := variant.CacheKey()
-	response, key, found := h.fetchFromCache(r, variant, baseKey)
+	reqCC := requestCacheControl(r)
+	response, key, found := h.lookup(r, variant, baseKey)
 
+	// expired is a copy that may still replace an upstream error or be
+	// revalidated with a conditional request
+	var expired *CacheableResponse
 	if found {
-		variant.SetResponseHeader(response.HttpHeader)
-		h.rememberVariantHeaders(baseKey, variant.HeaderNames())
-		if !variant.Matches(response.VariantHeader) {
-			response, key, found = h.fetchFromCache(r, variant, baseKey)
+		now := time.Now()
+		switch {
+		case response.usableFor(reqCC, now):
+			if response.isFresh(now) {
+				response.WriteCachedResponse(w, r)
+			} else {
+				// the client accepts it stale with max-stale
+				response.writeCached(w, r, cacheStatusStale)
+			}
+			return
+		case !reqCC.has("no-cache") && !reqCC.has("max-age") && response.canServeWhileRevalidating(now):
+			response.writeCached(w, r, cacheStatusStale)
+			h.revalidate(r, key, response)
+			return
+		case response.canServeOnError(now) || response.hasValidators():
+			expired = &response
 		}
 	}
 
-	if found {
-		response.WriteCachedResponse(w, r)
+	if reqCC.has("only-if-cached") {
+		w.Header().Set("X-Cache", cacheStatusMiss)
+		w.WriteHeader(http.StatusGatewayTimeout)
 		return
 	}
 
-	if !h.shouldCacheRequest(r) {
+	if !h.shouldCacheRequest(r) || (isRangeRequest(r) && expired == nil && !h.rangeFill) {
 		logger.Debug("proxy cache: bypassing request", logger.String("path", r.URL.Path), logger.String("method", r.Method))
-		w.Header().Set("X-Cache", "bypass")
+		w.Header().Set("X-Cache", cacheStatusBypass)
 		h.next.ServeHTTP(w, r)
 		return
 	}
 
-	cr := NewCacheableResponse(w, h.maxBodySize)
+	// one request per key fetches from the upstream; the others wait for it
+	// and are served what it stored, unless the last response could not be
+	// stored and there would be nothing to serve
+	if h.flights.passing(baseKey) {
+		logger.Debug("proxy cache: not collapsing request for uncacheable response", logger.String("path", r.URL.Path))
+	} else if done, leader := h.flights.join(key); leader {
+		defer h.flights.finish(key, done)
+	} else if h.awaitFlight(r, done) {
+		if response, _, found := h.lookup(r, variant, baseKey); found && response.usableFor(reqCC, time.Now()) {
+			response.WriteCachedResponse(w, r)
+			return
+		}
+	}
+
+	if expired != nil {
+		h.refetch(w, r, variant, baseKey, expired)
+		return
+	}
+
+	if isRangeRequest(r) {
+		h.fillRange(w, r, variant, baseKey)
+		return
+	}
+
+	cr := h.newCacheableResponse(w, r)
 	h.next.ServeHTTP(cr, r)
+	h.store(r, variant, baseKey, cr)
+}
 
-	cacheable, expires := cr.CacheStatus()
-	if cacheable {
-		variant.SetResponseHeader(cr.HttpHeader)
+// newCacheableResponse captures the upstream response to r, applying the TTL
+// of the matching path rule.
+func (h *CacheHandler) newCacheableResponse(w http.ResponseWriter, r *http.Request) *CacheableResponse {
+	cr := NewCacheableResponse(w, h.maxBodySize)
+	if rule := h.rules.pathRule(r.URL.Path); rule != nil {
+		cr.ttl = rule.TTL
+	}
+	return cr
+}
+
+// lookup finds the cached response for the request, following the Vary
+// headers the stored response names.
+func (h *CacheHandler) lookup(r *http.Request, variant *Variant, baseKey CacheKey) (CacheableResponse, CacheKey, bool) {
+	response, key, found := h.fetchFromCache(r, variant, baseKey)
+
+	if found {
+		variant.SetResponseHeader(response.HttpHeader)
 		h.rememberVariantHeaders(baseKey, variant.HeaderNames())
-		key = variant.CacheKey()
-		cr.VariantHeader = variant.VariantHeader()
-
-		encoded, err := cr.ToBuffer()
-		if err != nil {
-			logger.Error("proxy cache: encode response failed", logger.String("path", r.URL.Path), logger.Err(err))
-		} else {
-			h.cache.Set(key, encoded, expires)
-			logger.Debug("proxy cache: stored response", logger.String("path", r.URL.Path), logger.Any("key", key), logger.Time("expires", expires), logger.Int("size", len(encoded)))
+		if !variant.Matches(response.VariantHeader) {
+			response, key, found = h.fetchFromCache(r, variant, baseKey)
 		}
 	}
+
+	return response, key, found
 }
 
-// Private
+// store saves a response that qualifies for caching. It stays in the backend
+// for the longer of its stale windows after it stops being fresh. Whether it
+// qualified decides if requests for it are collapsed.
+func (h *CacheHandler) store(r *http.Request, variant *Variant, baseKey CacheKey, cr *CacheableResponse) {
+	cacheable, expires := cr.CacheStatus()
+	if !cacheable || !cr.storableFor(r) {
+		// upstream errors say nothing about the response, collapsing protects
+		// the upstream while they last
+		if cr.StatusCode < http.StatusInternalServerError {
+			h.flights.pass(baseKey)
+		}
+		return
+	}
+	h.flights.unpass(baseKey)
+
+	variant.SetResponseHeader(cr.HttpHeader)
+	h.rememberVariantHeaders(baseKey, variant.HeaderNames())
+	key := variant.CacheKey()
+	cr.VariantHeader = variant.VariantHeader()
+	cr.RequestHost = r.Host
+	cr.RequestPath = r.URL.Path
+	cr.RequestQuery = h.rules.normalizeQuery(r.URL.Query())
+	cr.SurrogateKeys = surrogateKeys(cr.HttpHeader)
+	cr.Expires = expires
+	cr.StaleWhileRevalidate, cr.StaleIfError = cr.staleWindows()
+	keep := max(cr.StaleWhileRevalidate, cr.StaleIfError)
+	if cr.hasValidators() {
+		keep = max(keep, h.revalidationWindow)
+	}
+	storeUntil := expires.Add(keep)
+
+	encoded, err := cr.ToBuffer()
+	if err != nil {
+		logger.Error("proxy cache: encode response failed", logger.String("path", r.URL.Path), logger.Err(err))
+		return
+	}
+
+	meta := cr.meta()
+	meta.expiresAt = storeUntil
+	if indexed, ok := h.cache.(indexedCache); ok {
+		indexed.setIndexed(key, encoded, meta)
+	} else {
+		h.cache.Set(key, encoded, storeUntil)
+	}
+	h.stats.recordStore(r.Host)
+	logger.Debug("proxy cache: stored response", logger.String("path", r.URL.Path), logger.Any("key", key), logger.Time("expires", expires), logger.Int("size", len(encoded)))
+}
 
 func (h *CacheHandler) fetchFromCache(r *http.Request, variant *Variant, baseKey CacheKey) (CacheableResponse, CacheKey, bool) {
 	if headerNames := h.loadVariantHeaders(baseKey); len(headerNames) > 0 {
@@ -147,7 +326,6 @@ func (h *CacheHandler) loadVariantHeaders(baseKey CacheKey) []string {
 func (h *CacheHandler) shouldCacheRequest(r *http.Request) bool {
 	allowedMethod := r.Method == http.MethodGet || r.Method == http.MethodHead
 	isUpgrade := r.Header.Get("Connection") == "Upgrade" || r.Header.Get("Upgrade") == "websocket"
-	isRange := r.Header.Get("Range") != ""
 
-	return allowedMethod && !isUpgrade && !isRange
+	return allowedMethod && !isUpgrade
 }
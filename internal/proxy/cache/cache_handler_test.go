@@ -13,6 +13,7 @@ import (
 type recordingCacheEntry struct {
 	value     []byte
 	expiresAt time.Time
+	meta      entryMeta
 }
 
 type recordingCache struct {
@@ -42,13 +43,52 @@ func (c *recordingCache) Get(key CacheKey) ([]byte, bool) {
 }
 
 func (c *recordingCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	c.setIndexed(key, value, entryMeta{expiresAt: expiresAt})
+}
+
+func (c *recordingCache) setIndexed(key CacheKey, value []byte, meta entryMeta) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	meta.size = len(value)
 	c.entries[key] = recordingCacheEntry{
 		value:     append([]byte(nil), value...),
-		expiresAt: expiresAt,
+		expiresAt: meta.expiresAt,
+		meta:      meta,
+	}
+}
+
+func (c *recordingCache) list(filter *PurgeFilter, fn func(key CacheKey, meta entryMeta)) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, entry := range c.entries {
+		if entry.expiresAt.After(time.Now()) && filter.matches(entry.meta) {
+			fn(key, entry.meta)
+		}
 	}
+	return nil
+}
+
+func (c *recordingCache) count() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), nil
+}
+
+func (c *recordingCache) Delete(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *recordingCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[CacheKey]recordingCacheEntry)
 }
 
 func (c *recordingCache) Contains(key CacheKey) bool {
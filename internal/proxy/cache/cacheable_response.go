@@ -5,36 +5,59 @@ import (
 	"encoding/gob"
 	"io"
 	"net/http"
-	"regexp"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
 )
 
-var (
-	publicExp  = regexp.MustCompile(`\bpublic\b`)
-	noCacheExp = regexp.MustCompile(`\bno-cache\b`)
-	sMaxAgeExp = regexp.MustCompile(`\bs-max-age=(\d+)\b`)
-	maxAgeExp  = regexp.MustCompile(`\bmax-age=(\d+)\b`)
+// Values of the X-Cache header.
+const (
+	cacheStatusHit   = "hit"
+	cacheStatusMiss  = "miss"
+	cacheStatusStale = "stale"
+	// the expired entry was confirmed by a 304 from the upstream
+	cacheStatusRevalidated = "revalidated"
+	// the request was passed to the upstream without consulting the cache
+	cacheStatusBypass = "bypass"
 )
 
+// notModifiedSkipHeaders are not taken over from a 304 into the stored response.
+var notModifiedSkipHeaders = []string{"Content-Length", "Content-Encoding", "Content-Type", "X-Cache"}
+
 // CacheableResponse captures enough of the downstream response to replay it from cache.
 type CacheableResponse struct {
 	StatusCode    int
 	HttpHeader    http.Header
 	Body          []byte
 	VariantHeader http.Header
+	// Expires ends freshness; the RFC 5861 windows extend past it. Entries
+	// stored before it was recorded have a zero value and count as fresh.
+	Expires              time.Time
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// when the upstream was asked and answered, for the Age calculation
+	RequestTime  time.Time
+	ResponseTime time.Time
+	// what the response was for, so it can be found by a purge
+	RequestHost   string
+	RequestPath   string
+	RequestQuery  string
+	SurrogateKeys []string
 
 	responseWriter http.ResponseWriter
 	stasher        *stashingWriter
 	headersWritten bool
+	// ttl, when set by a path rule, replaces the upstream's freshness lifetime
+	ttl time.Duration
 }
 
 // NewCacheableResponse wraps the downstream writer, retaining the response in memory up to maxBodyLength.
 func NewCacheableResponse(w http.ResponseWriter, maxBodyLength int) *CacheableResponse {
 	return &CacheableResponse{
-		StatusCode: http.StatusOK,
-		HttpHeader: http.Header{},
+		StatusCode:  http.StatusOK,
+		HttpHeader:  http.Header{},
+		RequestTime: time.Now(),
 
 		responseWriter: w,
 		stasher:        NewStashingWriter(maxBodyLength, w),
@@ -52,7 +75,9 @@ func CacheableResponseFromBuffer(b []byte) (CacheableResponse, error) {
 
 // ToBuffer serialises all cached response fields for storage.
 func (c *CacheableResponse) ToBuffer() ([]byte, error) {
-	c.Body = c.stasher.Body()
+	if c.stasher != nil {
+		c.Body = c.stasher.Body()
+	}
 
 	headerForStorage := cloneHeader(c.HttpHeader)
 	if cacheable, _ := c.CacheStatus(); cacheable {
@@ -88,7 +113,8 @@ func (c *CacheableResponse) Write(bytes []byte) (int, error) {
 // WriteHeader implements http.ResponseWriter.
 func (c *CacheableResponse) WriteHeader(statusCode int) {
 	c.StatusCode = statusCode
-	c.copyHeaders(c.responseWriter, false, c.StatusCode)
+	c.ResponseTime = time.Now()
+	c.copyHeaders(c.responseWriter, cacheStatusMiss, c.StatusCode)
 	c.headersWritten = true
 }
 
@@ -99,13 +125,16 @@ func (c *CacheableResponse) Flush() {
 	}
 }
 
-// CacheStatus reports whether the response qualifies for caching along with cache expiry.
+// CacheStatus reports whether a shared cache may store the response under RFC
+// 9111 along with the time it stops being fresh. Only responses with an
+// explicit lifetime are stored; no heuristic freshness is applied.
 func (c *CacheableResponse) CacheStatus() (bool, time.Time) {
-	if c.stasher.Overflowed() {
+	if c.stasher != nil && c.stasher.Overflowed() {
 		return false, time.Time{}
 	}
 
-	if c.StatusCode < 200 || c.StatusCode > 399 || c.StatusCode == http.StatusNotModified {
+	// partial content is never stored; ranges are served from full responses
+	if c.StatusCode < 200 || c.StatusCode > 399 || c.StatusCode == http.StatusNotModified || c.StatusCode == http.StatusPartialContent {
 		return false, time.Time{}
 	}
 
@@ -113,68 +142,240 @@ func (c *CacheableResponse) CacheStatus() (bool, time.Time) {
 		return false, time.Time{}
 	}
 
-	cc := c.HttpHeader.Get("Cache-Control")
-
-	if !publicExp.MatchString(cc) || noCacheExp.MatchString(cc) {
+	cc := parseCacheControl(c.HttpHeader)
+	// qualified forms such as private="Set-Cookie" are treated as unqualified
+	if cc.has("no-store") || cc.has("private") {
 		return false, time.Time{}
 	}
 
-	matches := sMaxAgeExp.FindStringSubmatch(cc)
-	if len(matches) != 2 {
-		matches = maxAgeExp.FindStringSubmatch(cc)
+	lifetime, ok := freshnessLifetime(c.HttpHeader, cc)
+	if c.ttl > 0 {
+		lifetime, ok = c.ttl, true
+	} else if cc.has("no-cache") {
+		lifetime = 0
 	}
-	if len(matches) != 2 {
+	if !ok {
 		return false, time.Time{}
 	}
-
-	maxAge, err := strconv.Atoi(matches[1])
-	if err != nil || maxAge <= 0 {
+	// an expired response is only worth storing for the stale windows or to revalidate
+	if swr, sie := c.staleWindows(); lifetime == 0 && swr == 0 && sie == 0 && !c.hasValidators() {
 		return false, time.Time{}
 	}
 
-	return true, time.Now().Add(time.Duration(maxAge) * time.Second)
+	responseTime := c.ResponseTime
+	if responseTime.IsZero() {
+		responseTime = time.Now()
+	}
+	requestTime := c.RequestTime
+	if requestTime.IsZero() {
+		requestTime = responseTime
+	}
+	return true, responseTime.Add(lifetime - correctedInitialAge(c.HttpHeader, requestTime, responseTime))
 }
 
 // WriteCachedResponse replays a cached response to the client, respecting conditional headers.
 func (c *CacheableResponse) WriteCachedResponse(w http.ResponseWriter, r *http.Request) {
+	c.writeCached(w, r, cacheStatusHit)
+}
+
+// Private
+
+func (c *CacheableResponse) writeCached(w http.ResponseWriter, r *http.Request, cacheStatus string) {
 	if c.wasNotModified(r) {
-		c.copyHeaders(w, true, http.StatusNotModified)
+		c.copyHeaders(w, cacheStatus, http.StatusNotModified)
+	} else if isRangeRequest(r) && c.StatusCode == http.StatusOK {
+		c.writeRanges(w, r, cacheStatus)
 	} else {
-		c.copyHeaders(w, true, c.StatusCode)
+		c.copyHeaders(w, cacheStatus, c.StatusCode)
 		_, _ = io.Copy(w, bytes.NewReader(c.Body))
 	}
 }
 
-// Private
+// staleWindows returns the RFC 5861 stale-while-revalidate and stale-if-error
+// durations of the response. Responses that must be revalidated have none.
+func (c *CacheableResponse) staleWindows() (time.Duration, time.Duration) {
+	if c.mustRevalidate() {
+		return 0, 0
+	}
+	cc := parseCacheControl(c.HttpHeader)
+	swr, _ := cc.seconds("stale-while-revalidate")
+	sie, _ := cc.seconds("stale-if-error")
+	return swr, sie
+}
+
+// mustRevalidate reports whether a shared cache may not serve the response
+// once it is stale (RFC 9111 sections 5.2.2.2, 5.2.2.4, 5.2.2.8 and 5.2.2.10).
+func (c *CacheableResponse) mustRevalidate() bool {
+	cc := parseCacheControl(c.HttpHeader)
+	return cc.has("must-revalidate") || cc.has("proxy-revalidate") || cc.has("s-maxage") || cc.has("no-cache")
+}
+
+func (c *CacheableResponse) isFresh(now time.Time) bool {
+	return c.Expires.IsZero() || now.Before(c.Expires)
+}
 
+// currentAge is the Age of the response at now (RFC 9111 section 4.2.3).
+func (c *CacheableResponse) currentAge(now time.Time) time.Duration {
+	if c.ResponseTime.IsZero() {
+		return 0
+	}
+	requestTime := c.RequestTime
+	if requestTime.IsZero() {
+		requestTime = c.ResponseTime
+	}
+	return correctedInitialAge(c.HttpHeader, requestTime, c.ResponseTime) + max(now.Sub(c.ResponseTime), 0)
+}
+
+// usableFor reports whether the stored response satisfies the request's
+// Cache-Control directives (RFC 9111 section 5.2.1) without contacting the
+// upstream: no-cache, max-age, min-fresh and max-stale.
+func (c *CacheableResponse) usableFor(reqCC cacheControl, now time.Time) bool {
+	if reqCC.has("no-cache") {
+		return false
+	}
+	if maxAge, ok := reqCC.seconds("max-age"); ok && c.currentAge(now) > maxAge {
+		return false
+	}
+	if c.isFresh(now) {
+		minFresh, ok := reqCC.seconds("min-fresh")
+		return !ok || c.Expires.IsZero() || c.Expires.Sub(now) >= minFresh
+	}
+
+	if !reqCC.has("max-stale") || c.mustRevalidate() {
+		return false
+	}
+	maxStale, ok := reqCC.seconds("max-stale")
+	return !ok || now.Sub(c.Expires) <= maxStale
+}
+
+// storableFor applies the request side of RFC 9111 section 3: no-store in the
+// request, and Authorization unless the response explicitly allows sharing.
+func (c *CacheableResponse) storableFor(r *http.Request) bool {
+	if requestCacheControl(r).has("no-store") {
+		return false
+	}
+	if r.Header.Get("Authorization") != "" {
+		cc := parseCacheControl(c.HttpHeader)
+		return cc.has("public") || cc.has("s-maxage") || cc.has("must-revalidate")
+	}
+	return true
+}
+
+// canServeWhileRevalidating reports whether the stale copy may be served while
+// a fresh one is fetched in the background.
+func (c *CacheableResponse) canServeWhileRevalidating(now time.Time) bool {
+	return now.Before(c.Expires.Add(c.StaleWhileRevalidate))
+}
+
+// canServeOnError reports whether the stale copy may replace an upstream error.
+func (c *CacheableResponse) canServeOnError(now time.Time) bool {
+	return now.Before(c.Expires.Add(c.StaleIfError))
+}
+
+// wasNotModified evaluates the client's validators against the response as
+// RFC 9110 section 13.2.2 orders them: If-None-Match, using the weak
+// comparison, takes precedence over If-Modified-Since.
 func (c *CacheableResponse) wasNotModified(r *http.Request) bool {
-	responseEtag := c.HttpHeader.Get("Etag")
-	if responseEtag == "" {
+	if values := r.Header.Values("If-None-Match"); len(values) > 0 {
+		responseEtag := c.HttpHeader.Get("Etag")
+		if responseEtag == "" {
+			return false
+		}
+
+		for _, etag := range strings.Split(strings.Join(values, ","), ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" || weakETagMatch(etag, responseEtag) {
+				return true
+			}
+		}
 		return false
 	}
 
-	ifNoneMatch := strings.Split(r.Header.Get("If-None-Match"), ",")
-	for _, etag := range ifNoneMatch {
-		if strings.TrimSpace(etag) == responseEtag {
-			return true
+	ifModifiedSince := r.Header.Get("If-Modified-Since")
+	lastModified := c.HttpHeader.Get("Last-Modified")
+	if ifModifiedSince == "" || lastModified == "" {
+		return false
+	}
+	since, err := http.ParseTime(ifModifiedSince)
+	if err != nil {
+		return false
+	}
+	modified, err := http.ParseTime(lastModified)
+	if err != nil {
+		return false
+	}
+	return !modified.After(since)
+}
+
+// hasValidators reports whether the response can be revalidated with a
+// conditional request.
+func (c *CacheableResponse) hasValidators() bool {
+	return c.HttpHeader.Get("Etag") != "" || c.HttpHeader.Get("Last-Modified") != ""
+}
+
+// setConditionalHeaders makes a request conditional on the response's
+// validators, replacing any the client sent.
+func (c *CacheableResponse) setConditionalHeaders(header http.Header) {
+	header.Del("If-None-Match")
+	header.Del("If-Modified-Since")
+	if etag := c.HttpHeader.Get("Etag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := c.HttpHeader.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+}
+
+// refreshed returns a copy of the response with the headers of a 304 that
+// confirmed it, as RFC 9111 section 4.3.4 describes; the body is kept and the
+// age restarts from the 304.
+func (c *CacheableResponse) refreshed(notModified *CacheableResponse) *CacheableResponse {
+	refreshed := *c
+	refreshed.HttpHeader = cloneHeader(c.HttpHeader)
+	for k, v := range notModified.HttpHeader {
+		if !slices.Contains(notModifiedSkipHeaders, k) {
+			refreshed.HttpHeader[k] = v
 		}
 	}
+	refreshed.RequestTime = notModified.RequestTime
+	refreshed.ResponseTime = notModified.ResponseTime
+	refreshed.ttl = notModified.ttl
+	return &refreshed
+}
+
+// weakETagMatch compares entity tags ignoring the weak indicator.
+func weakETagMatch(a, b string) bool {
+	return strings.TrimPrefix(a, "W/") == strings.TrimPrefix(b, "W/")
+}
+
+func (c *CacheableResponse) meta() entryMeta {
+	return entryMeta{
+		host:    c.RequestHost,
+		path:    c.RequestPath,
+		query:   c.RequestQuery,
+		tags:    c.SurrogateKeys,
+		variant: c.VariantHeader,
+		expires: c.Expires,
+	}
+}
 
-	return false
+func (c *CacheableResponse) copyHeaders(w http.ResponseWriter, cacheStatus string, statusCode int) {
+	c.setHeaders(w, cacheStatus)
+	w.WriteHeader(statusCode)
 }
 
-func (c *CacheableResponse) copyHeaders(w http.ResponseWriter, wasHit bool, statusCode int) {
+func (c *CacheableResponse) setHeaders(w http.ResponseWriter, cacheStatus string) {
 	for k, v := range c.HttpHeader {
 		w.Header()[k] = v
 	}
+	// purge tags are meant for this cache only
+	w.Header().Del(surrogateKeyHeader)
+	w.Header().Del(cacheTagHeader)
 
-	if wasHit {
-		w.Header().Set("X-Cache", "hit")
-	} else {
-		w.Header().Set("X-Cache", "miss")
+	w.Header().Set("X-Cache", cacheStatus)
+	if cacheStatus != cacheStatusMiss {
+		w.Header().Set("Age", strconv.FormatInt(int64(c.currentAge(time.Now())/time.Second), 10))
 	}
-
-	w.WriteHeader(statusCode)
 }
 
 func cloneHeader(src http.Header) http.Header {
@@ -0,0 +1,107 @@
+package proxycache
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	defaultCollapseTimeout = 5 * time.Second
+
+	// passTTL is how long requests for a response that could not be stored are
+	// not collapsed, so waiting for another request never delays pages that
+	// are never cached, like those private to a session
+	passTTL = 30 * time.Second
+	// the passes are pruned of expired keys once there are this many
+	passPruneSize = 10000
+)
+
+// flights tracks the cache misses being fetched from the upstream.
+type flights struct {
+	mu       sync.Mutex
+	inFlight map[CacheKey]chan struct{}
+	// passes holds until when each key is not collapsed
+	passes map[CacheKey]time.Time
+}
+
+func newFlights() *flights {
+	return &flights{inFlight: make(map[CacheKey]chan struct{}), passes: make(map[CacheKey]time.Time)}
+}
+
+// join returns the channel closed when the fetch of key finishes, and whether
+// the caller is the one to do it.
+func (f *flights) join(key CacheKey) (chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if done, ok := f.inFlight[key]; ok {
+		return done, false
+	}
+	done := make(chan struct{})
+	f.inFlight[key] = done
+	return done, true
+}
+
+func (f *flights) finish(key CacheKey, done chan struct{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.inFlight, key)
+	close(done)
+}
+
+// pass stops collapsing requests for key for passTTL.
+func (f *flights) pass(key CacheKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	if len(f.passes) >= passPruneSize {
+		for k, until := range f.passes {
+			if !until.After(now) {
+				delete(f.passes, k)
+			}
+		}
+	}
+	f.passes[key] = now.Add(passTTL)
+}
+
+// unpass collapses requests for key again, once a response to it was stored.
+func (f *flights) unpass(key CacheKey) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.passes, key)
+}
+
+func (f *flights) passing(key CacheKey) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	until, ok := f.passes[key]
+	if ok && !until.After(time.Now()) {
+		delete(f.passes, key)
+		return false
+	}
+	return ok
+}
+
+// awaitFlight waits for the request fetching the same response. It reports
+// false when the wait timed out or the client went away.
+func (h *CacheHandler) awaitFlight(r *http.Request, done chan struct{}) bool {
+	timer := time.NewTimer(h.collapseTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return true
+	case <-timer.C:
+		logger.Debug("proxy cache: gave up waiting for collapsed request", logger.String("path", r.URL.Path), logger.Duration("timeout", h.collapseTimeout))
+		return false
+	case <-r.Context().Done():
+		return false
+	}
+}
@@ -0,0 +1,117 @@
+package proxycache
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// blockingOrigin holds the first request until release is closed.
+func blockingOrigin(hits *atomic.Int32, entered, release chan struct{}) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hits.Add(1) == 1 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = w.Write([]byte("popular"))
+	})
+}
+
+func TestCacheHandlerCollapsesConcurrentMisses(t *testing.T) {
+	var hits atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := NewCacheHandler(newRecordingCache(), 1024, blockingOrigin(&hits, entered, release))
+
+	var wg sync.WaitGroup
+	statuses := make([]string, 10)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		statuses[0] = serveRequest(h, "http://example.com/popular").Header().Get("X-Cache")
+	}()
+	<-entered
+
+	for i := 1; i < len(statuses); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rr := serveRequest(h, "http://example.com/popular")
+			assert.Equal(t, "popular", rr.Body.String())
+			statuses[i] = rr.Header().Get("X-Cache")
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), hits.Load())
+	assert.Equal(t, "miss", statuses[0])
+	for _, status := range statuses[1:] {
+		assert.Equal(t, "hit", status)
+	}
+}
+
+func TestCacheHandlerCollapseTimesOut(t *testing.T) {
+	var hits atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	h := NewCacheHandler(newRecordingCache(), 1024, blockingOrigin(&hits, entered, release), WithCollapseTimeout(20*time.Millisecond))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveRequest(h, "http://example.com/popular")
+	}()
+	<-entered
+
+	rr := serveRequest(h, "http://example.com/popular")
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	assert.Equal(t, int32(2), hits.Load())
+
+	close(release)
+	<-done
+}
+
+func TestCacheHandlerDoesNotCollapseUncacheableResponses(t *testing.T) {
+	var hits atomic.Int32
+	entered, release := make(chan struct{}), make(chan struct{})
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the second request, the first once the response is known to be uncacheable, is held
+		if hits.Add(1) == 2 {
+			close(entered)
+			<-release
+		}
+		w.Header().Set("Cache-Control", "private")
+		_, _ = w.Write([]byte("dashboard"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin, WithCollapseTimeout(time.Hour))
+
+	assert.Equal(t, "miss", serveRequest(h, "http://example.com/dashboard").Header().Get("X-Cache"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		serveRequest(h, "http://example.com/dashboard")
+	}()
+	<-entered
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		assert.Equal(t, "dashboard", serveRequest(h, "http://example.com/dashboard").Body.String())
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Error("request for an uncacheable response waited for another one")
+	}
+
+	close(release)
+	<-done
+	<-finished
+	assert.Equal(t, int32(3), hits.Load())
+}
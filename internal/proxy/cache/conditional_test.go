@@ -0,0 +1,109 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheHandlerRevalidatesExpiredEntries(t *testing.T) {
+	var full, notModified atomic.Int32
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		full.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=0")
+		w.Header().Set("Etag", `"v1"`)
+		_, _ = w.Write([]byte("body"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+
+	rr := serveRequest(h, "http://example.com/doc")
+	require.Equal(t, "miss", rr.Header().Get("X-Cache"))
+
+	rr = serveRequest(h, "http://example.com/doc")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "revalidated", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "body", rr.Body.String())
+	assert.Equal(t, "public, max-age=60", rr.Header().Get("Cache-Control"))
+
+	rr = serveRequest(h, "http://example.com/doc")
+	assert.Equal(t, "hit", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "body", rr.Body.String())
+
+	assert.Equal(t, int32(1), full.Load())
+	assert.Equal(t, int32(1), notModified.Load())
+}
+
+func TestCacheHandlerAnswersClientValidatorsAfterRevalidation(t *testing.T) {
+	var calls atomic.Int32
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) > 1 {
+			// the cache's validators, not the client's
+			assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", r.Header.Get("If-Modified-Since"))
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=0")
+		w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+		_, _ = w.Write([]byte("body"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+	require.Equal(t, "miss", serveRequest(h, "http://example.com/doc").Header().Get("X-Cache"))
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/doc", nil)
+	req.Header.Set("If-Modified-Since", "Tue, 03 Jan 2006 15:04:05 GMT")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	assert.Equal(t, http.StatusNotModified, rr.Code)
+	assert.Equal(t, "revalidated", rr.Header().Get("X-Cache"))
+	assert.Empty(t, rr.Body.String())
+}
+
+func TestCacheableResponseWasNotModified(t *testing.T) {
+	lastModified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		etag   string
+		header map[string]string
+		want   bool
+	}{
+		{"strong etag", `"abc"`, map[string]string{"If-None-Match": `"abc"`}, true},
+		{"weak client etag", `"abc"`, map[string]string{"If-None-Match": `W/"abc"`}, true},
+		{"weak stored etag", `W/"abc"`, map[string]string{"If-None-Match": `"xyz", "abc"`}, true},
+		{"star", `"abc"`, map[string]string{"If-None-Match": `*`}, true},
+		{"other etag", `"abc"`, map[string]string{"If-None-Match": `"xyz"`}, false},
+		{"etag wins over date", `"abc"`, map[string]string{"If-None-Match": `"xyz"`, "If-Modified-Since": lastModified.Format(http.TimeFormat)}, false},
+		{"not modified since", "", map[string]string{"If-Modified-Since": lastModified.Add(time.Hour).Format(http.TimeFormat)}, true},
+		{"modified since", "", map[string]string{"If-Modified-Since": lastModified.Add(-time.Hour).Format(http.TimeFormat)}, false},
+		{"bad date", "", map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{"no validators", "", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cr := CacheableResponse{HttpHeader: http.Header{}}
+			cr.HttpHeader.Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			if tt.etag != "" {
+				cr.HttpHeader.Set("Etag", tt.etag)
+			}
+			req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			assert.Equal(t, tt.want, cr.wasNotModified(req))
+		})
+	}
+}
@@ -0,0 +1,428 @@
+package proxycache
+
+import (
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	diskEntryMagic      = "TPC2"
+	diskEntryHeaderSize = len(diskEntryMagic) + 8 + 8 + 4 + 4 // magic, expiry, length, checksum, metadata length
+	diskEntryExt        = ".entry"
+	diskTempDir         = "tmp"
+
+	// diskTouchInterval bounds how often a hit rewrites the modification time
+	// of an entry file. The order recovered after a restart only needs to be
+	// roughly right, and touching the file on every hit would turn reads into
+	// metadata writes.
+	diskTouchInterval = time.Minute
+)
+
+var errCorruptEntry = errors.New("corrupt cache entry")
+
+// DiskCache stores entries as files under a directory so the cache survives
+// restarts and is not bounded by memory. Each entry is one file holding a small
+// header (expiry, length and checksum) followed by the metadata purges select
+// entries by and the value; files are written to a temporary name and renamed
+// into place, so readers never see partial entries. The total size is bounded
+// by capacity with least recently used eviction, tracked in an in-memory index
+// rebuilt from the files on startup that also holds the metadata.
+type DiskCache struct {
+	dir            string
+	capacity       int64
+	maxItemSize    int
+	getCurrentTime GetCurrentTime
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[CacheKey]*list.Element
+	size    int64
+}
+
+type diskEntry struct {
+	key       CacheKey
+	size      int64
+	expiresAt time.Time
+	// touched is the last use recorded in the modification time of the file
+	touched time.Time
+	meta    entryMeta
+}
+
+// NewDiskCache opens or creates a disk cache in dir bounded by capacity and
+// per-item size, recovering the entries a previous run left behind.
+func NewDiskCache(dir string, capacity, maxItemSize int) (*DiskCache, error) {
+	if dir == "" {
+		return nil, errors.New("disk cache directory is required")
+	}
+	if err := os.MkdirAll(filepath.Join(dir, diskTempDir), 0o750); err != nil {
+		return nil, fmt.Errorf("create disk cache directory: %w", err)
+	}
+
+	c := &DiskCache{
+		dir:            dir,
+		capacity:       int64(capacity),
+		maxItemSize:    maxItemSize,
+		getCurrentTime: time.Now,
+		lru:            list.New(),
+		entries:        make(map[CacheKey]*list.Element),
+	}
+	if err := c.recover(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Set writes a value to disk if it fits per-item limits, evicting the least
+// recently used entries to stay within capacity.
+func (c *DiskCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	c.set(key, value, entryMeta{expiresAt: expiresAt}, nil)
+}
+
+func (c *DiskCache) setIndexed(key CacheKey, value []byte, meta entryMeta) {
+	encodedMeta, err := meta.encode()
+	if err != nil {
+		logger.Warn("proxy cache: failed to encode disk entry metadata", logger.Any("key", key), logger.Err(err))
+		return
+	}
+	c.set(key, value, meta, encodedMeta)
+}
+
+func (c *DiskCache) set(key CacheKey, value []byte, meta entryMeta, encodedMeta []byte) {
+	meta.size = len(value)
+	itemSize := int64(diskEntryHeaderSize + len(encodedMeta) + len(value))
+	if len(value) > c.maxItemSize || (c.capacity > 0 && itemSize > c.capacity) {
+		logger.Debug(
+			"proxy cache: item too large",
+			logger.Int("item_size", len(value)),
+			logger.Int("max_item_size", c.maxItemSize),
+			logger.Int64("capacity", c.capacity),
+		)
+		return
+	}
+	expiresAt := meta.expiresAt
+	if !expiresAt.After(c.getCurrentTime()) {
+		logger.Debug("proxy cache: item already expired", logger.Any("key", key), logger.Time("expires_at", expiresAt))
+		return
+	}
+
+	if err := c.writeEntry(key, encodedMeta, value, expiresAt); err != nil {
+		logger.Warn("proxy cache: failed to write disk entry", logger.Any("key", key), logger.Err(err))
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.forget(key)
+	c.entries[key] = c.lru.PushFront(&diskEntry{key: key, size: itemSize, expiresAt: expiresAt, touched: c.getCurrentTime(), meta: meta})
+	c.size += itemSize
+	c.evict()
+}
+
+// Get reads an entry when present and not expired. Entries that fail their
+// checksum are removed and reported as misses.
+func (c *DiskCache) Get(key CacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	elem, ok := c.entries[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false
+	}
+	entry := elem.Value.(*diskEntry)
+	now := c.getCurrentTime()
+	if !entry.expiresAt.After(now) {
+		c.remove(elem)
+		c.mu.Unlock()
+		return nil, false
+	}
+	c.lru.MoveToFront(elem)
+	touch := now.Sub(entry.touched) >= diskTouchInterval
+	if touch {
+		entry.touched = now
+	}
+	c.mu.Unlock()
+
+	value, expiresAt, err := readDiskEntry(c.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			logger.Warn("proxy cache: dropping unreadable disk entry", logger.Any("key", key), logger.Err(err))
+		}
+		c.mu.Lock()
+		if elem, ok := c.entries[key]; ok && elem.Value == entry {
+			c.remove(elem)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	if !expiresAt.After(now) {
+		return nil, false
+	}
+
+	// the modification time records recency for the LRU order after a restart
+	if touch {
+		_ = os.Chtimes(c.path(key), now, now)
+	}
+	return value, true
+}
+
+// Delete removes an entry and its file.
+func (c *DiskCache) Delete(key CacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+// Clear removes every entry.
+func (c *DiskCache) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// Private
+
+// list reads the in-memory index only.
+func (c *DiskCache) list(filter *PurgeFilter, fn func(key CacheKey, meta entryMeta)) error {
+	now := c.getCurrentTime()
+	var keys []CacheKey
+	var metas []entryMeta
+	c.mu.Lock()
+	for key, elem := range c.entries {
+		entry := elem.Value.(*diskEntry)
+		if entry.expiresAt.After(now) && filter.matches(entry.meta) {
+			keys = append(keys, key)
+			metas = append(metas, entry.meta)
+		}
+	}
+	c.mu.Unlock()
+
+	for i, key := range keys {
+		fn(key, metas[i])
+	}
+	return nil
+}
+
+func (c *DiskCache) count() (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), nil
+}
+
+func (c *DiskCache) path(key CacheKey) string {
+	name := fmt.Sprintf("%016x", uint64(key))
+	return filepath.Join(c.dir, name[:2], name+diskEntryExt)
+}
+
+func (c *DiskCache) writeEntry(key CacheKey, meta, value []byte, expiresAt time.Time) error {
+	tmp, err := os.CreateTemp(filepath.Join(c.dir, diskTempDir), "entry-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) //nolint
+
+	header := make([]byte, diskEntryHeaderSize)
+	copy(header, diskEntryMagic)
+	binary.BigEndian.PutUint64(header[4:], uint64(expiresAt.UnixNano()))
+	binary.BigEndian.PutUint64(header[12:], uint64(len(value)))
+	binary.BigEndian.PutUint32(header[20:], crc32.ChecksumIEEE(value))
+	binary.BigEndian.PutUint32(header[24:], uint32(len(meta)))
+
+	_, err = tmp.Write(header)
+	if err == nil {
+		_, err = tmp.Write(meta)
+	}
+	if err == nil {
+		_, err = tmp.Write(value)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	path := c.path(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func readDiskEntry(path string) ([]byte, time.Time, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	expiresAt, length, checksum, metaLength, err := parseDiskEntryHeader(data)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	if int64(len(data)) < int64(diskEntryHeaderSize)+metaLength {
+		return nil, time.Time{}, errCorruptEntry
+	}
+
+	value := data[int64(diskEntryHeaderSize)+metaLength:]
+	if int64(len(value)) != length || crc32.ChecksumIEEE(value) != checksum {
+		return nil, time.Time{}, errCorruptEntry
+	}
+	return value, expiresAt, nil
+}
+
+// parseDiskEntryHeader returns the expiry, value length, value checksum and
+// metadata length of an entry.
+func parseDiskEntryHeader(header []byte) (time.Time, int64, uint32, int64, error) {
+	if len(header) < diskEntryHeaderSize || string(header[:4]) != diskEntryMagic {
+		return time.Time{}, 0, 0, 0, errCorruptEntry
+	}
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(header[4:])))
+	length := int64(binary.BigEndian.Uint64(header[12:]))
+	metaLength := int64(binary.BigEndian.Uint32(header[24:]))
+	return expiresAt, length, binary.BigEndian.Uint32(header[20:]), metaLength, nil
+}
+
+// recover rebuilds the index from the entry files, ordered by their last use,
+// and removes expired, corrupt and half-written files.
+func (c *DiskCache) recover() error {
+	_ = os.RemoveAll(filepath.Join(c.dir, diskTempDir))
+	if err := os.MkdirAll(filepath.Join(c.dir, diskTempDir), 0o750); err != nil {
+		return err
+	}
+
+	type recovered struct {
+		entry   *diskEntry
+		lastUse time.Time
+	}
+	var found []recovered
+	now := c.getCurrentTime()
+	var dropped int
+
+	err := filepath.WalkDir(c.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || !strings.HasSuffix(path, diskEntryExt) {
+			return nil
+		}
+
+		key, entry, lastUse, err := inspectDiskEntry(path)
+		if err != nil || !entry.expiresAt.After(now) || path != c.path(key) {
+			_ = os.Remove(path)
+			dropped++
+			return nil
+		}
+		found = append(found, recovered{entry: entry, lastUse: lastUse})
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("recover disk cache: %w", err)
+	}
+
+	sort.Slice(found, func(i, j int) bool { return found[i].lastUse.After(found[j].lastUse) })
+	for _, r := range found {
+		c.entries[r.entry.key] = c.lru.PushBack(r.entry)
+		c.size += r.entry.size
+	}
+	c.evict()
+
+	logger.Info("proxy cache: disk cache recovered",
+		logger.String("dir", c.dir),
+		logger.Int("entries", len(c.entries)),
+		logger.Int64("size", c.size),
+		logger.Int("dropped", dropped))
+	return nil
+}
+
+// inspectDiskEntry reads only the header and metadata of an entry file.
+func inspectDiskEntry(path string) (CacheKey, *diskEntry, time.Time, error) {
+	n, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(path), diskEntryExt), 16, 64)
+	if err != nil {
+		return 0, nil, time.Time{}, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, nil, time.Time{}, err
+	}
+	defer f.Close() //nolint
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, nil, time.Time{}, err
+	}
+	header := make([]byte, diskEntryHeaderSize)
+	if _, err := io.ReadFull(f, header); err != nil {
+		return 0, nil, time.Time{}, err
+	}
+	expiresAt, length, _, metaLength, err := parseDiskEntryHeader(header)
+	if err != nil {
+		return 0, nil, time.Time{}, err
+	}
+	if info.Size() != int64(diskEntryHeaderSize)+metaLength+length {
+		return 0, nil, time.Time{}, errCorruptEntry
+	}
+	encodedMeta := make([]byte, metaLength)
+	if _, err := io.ReadFull(f, encodedMeta); err != nil {
+		return 0, nil, time.Time{}, err
+	}
+	var meta entryMeta
+	if metaLength > 0 {
+		if meta, err = decodeEntryMeta(encodedMeta); err != nil {
+			return 0, nil, time.Time{}, errCorruptEntry
+		}
+	}
+	meta.expiresAt, meta.size = expiresAt, int(length)
+
+	key := CacheKey(n)
+	entry := &diskEntry{key: key, size: info.Size(), expiresAt: expiresAt, touched: info.ModTime(), meta: meta}
+	return key, entry, info.ModTime(), nil
+}
+
+// evict drops least recently used entries until the cache fits its capacity.
+// The caller holds c.mu.
+func (c *DiskCache) evict() {
+	for c.capacity > 0 && c.size > c.capacity {
+		oldest := c.lru.Back()
+		if oldest == nil {
+			return
+		}
+		logger.Debug("proxy cache: evicting disk entry", logger.Any("key", oldest.Value.(*diskEntry).key))
+		c.remove(oldest)
+	}
+}
+
+// forget drops the index entry for key without touching its file, which has
+// just been replaced. The caller holds c.mu.
+func (c *DiskCache) forget(key CacheKey) {
+	if elem, ok := c.entries[key]; ok {
+		c.size -= elem.Value.(*diskEntry).size
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+	}
+}
+
+// remove drops an entry and its file. The caller holds c.mu.
+func (c *DiskCache) remove(elem *list.Element) {
+	entry := elem.Value.(*diskEntry)
+	c.forget(entry.key)
+	if err := os.Remove(c.path(entry.key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		logger.Warn("proxy cache: failed to remove disk entry", logger.Any("key", entry.key), logger.Err(err))
+	}
+}
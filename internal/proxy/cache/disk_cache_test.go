@@ -0,0 +1,144 @@
+package proxycache
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCacheSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+
+	cache.Set(1, []byte("first"), time.Now().Add(time.Hour))
+	cache.Set(2, []byte("expiring"), time.Now().Add(time.Hour))
+
+	value, ok := cache.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "first", string(value))
+
+	reopened, err := NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+	reopened.getCurrentTime = func() time.Time { return time.Now().Add(30 * time.Minute) }
+
+	value, ok = reopened.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "first", string(value))
+
+	// entries expired while the process was down are not recovered
+	cache.Set(3, []byte("short"), time.Now().Add(50*time.Millisecond))
+	time.Sleep(60 * time.Millisecond)
+	reopened, err = NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+	_, ok = reopened.Get(3)
+	assert.False(t, ok)
+	_, err = os.Stat(reopened.path(3))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	entrySize := diskEntryHeaderSize + 10
+	cache, err := NewDiskCache(t.TempDir(), 3*entrySize, 1024)
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	cache.Set(1, []byte("0123456789"), expires)
+	cache.Set(2, []byte("0123456789"), expires)
+	cache.Set(3, []byte("0123456789"), expires)
+
+	_, ok := cache.Get(1)
+	require.True(t, ok)
+
+	cache.Set(4, []byte("0123456789"), expires)
+
+	_, ok = cache.Get(2)
+	assert.False(t, ok, "least recently used entry should be evicted")
+	for _, key := range []CacheKey{1, 3, 4} {
+		_, ok = cache.Get(key)
+		assert.True(t, ok, "entry %d should be kept", key)
+	}
+	_, err = os.Stat(cache.path(2))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestDiskCacheThrottlesRecencyWrites(t *testing.T) {
+	cache, err := NewDiskCache(t.TempDir(), 1<<20, 1024)
+	require.NoError(t, err)
+	now := time.Now()
+	cache.getCurrentTime = func() time.Time { return now }
+
+	cache.Set(1, []byte("value"), now.Add(time.Hour))
+	old := now.Add(-time.Hour)
+	require.NoError(t, os.Chtimes(cache.path(1), old, old))
+	modTime := func() time.Time {
+		info, err := os.Stat(cache.path(1))
+		require.NoError(t, err)
+		return info.ModTime()
+	}
+
+	_, ok := cache.Get(1)
+	require.True(t, ok)
+	assert.True(t, modTime().Equal(old), "a hit right after the write must not touch the file")
+
+	now = now.Add(diskTouchInterval)
+	_, ok = cache.Get(1)
+	require.True(t, ok)
+	assert.True(t, modTime().Equal(now))
+}
+
+func TestDiskCacheToleratesCorruption(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+
+	expires := time.Now().Add(time.Hour)
+	cache.Set(1, []byte("intact"), expires)
+	cache.Set(2, []byte("flipped"), expires)
+	cache.Set(3, []byte("truncated"), expires)
+
+	data, err := os.ReadFile(cache.path(2))
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(cache.path(2), data, 0o600))
+
+	_, ok := cache.Get(2)
+	assert.False(t, ok, "entry failing its checksum must not be served")
+	_, err = os.Stat(cache.path(2))
+	assert.True(t, os.IsNotExist(err), "corrupt entry should be removed")
+
+	require.NoError(t, os.Truncate(cache.path(3), int64(diskEntryHeaderSize)+2))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, diskTempDir, "entry-123"), []byte("partial"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(filepath.Dir(cache.path(1)), "garbage"+diskEntryExt), []byte("x"), 0o600))
+
+	reopened, err := NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+
+	value, ok := reopened.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "intact", string(value))
+	_, ok = reopened.Get(3)
+	assert.False(t, ok)
+	assert.Len(t, reopened.entries, 1)
+
+	leftovers, err := os.ReadDir(filepath.Join(dir, diskTempDir))
+	require.NoError(t, err)
+	assert.Empty(t, leftovers)
+}
+
+func TestDiskCacheRejectsOversizedAndExpiredItems(t *testing.T) {
+	cache, err := NewDiskCache(t.TempDir(), 1<<20, 4)
+	require.NoError(t, err)
+
+	cache.Set(1, []byte("too large"), time.Now().Add(time.Hour))
+	cache.Set(2, []byte("ok"), time.Now().Add(-time.Second))
+
+	_, ok := cache.Get(1)
+	assert.False(t, ok)
+	_, ok = cache.Get(2)
+	assert.False(t, ok)
+}
@@ -0,0 +1,69 @@
+package proxycache
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Entry describes a cached response for inspection.
+type Entry struct {
+	Key CacheKey
+	// URL is the request host, path and normalized query the response answers.
+	URL string
+	// Size is the stored size of the entry in bytes.
+	Size int
+	// VariantHeader holds the request headers named by Vary that select this
+	// variant.
+	VariantHeader http.Header
+	// TTL is how long the response stays fresh; zero once it is stale and only
+	// kept for stale serving or revalidation.
+	TTL time.Duration
+}
+
+// Keys lists the cached responses selected by filter, ordered by URL, and
+// reports how many matched in total; at most limit are returned when limit is
+// positive. An empty filter selects everything.
+func (h *CacheHandler) Keys(filter PurgeFilter, limit int) ([]Entry, int, error) {
+	if filter.URL == "" && filter.Prefix == "" && filter.Host == "" && len(filter.Tags) == 0 {
+		filter.All = true
+	}
+	if err := filter.normalize(&h.rules); err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+	var entries []Entry
+	add := func(key CacheKey, meta entryMeta) {
+		url := meta.host + meta.path
+		if meta.query != "" {
+			url += "?" + meta.query
+		}
+		entries = append(entries, Entry{
+			Key:           key,
+			URL:           url,
+			Size:          meta.size,
+			VariantHeader: meta.variant,
+			TTL:           max(meta.expires.Sub(now), 0),
+		})
+	}
+
+	indexed, ok := h.cache.(indexedCache)
+	if !ok {
+		return nil, 0, ErrListingUnsupported
+	}
+	err := indexed.list(&filter, add)
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].URL != entries[j].URL {
+			return entries[i].URL < entries[j].URL
+		}
+		return entries[i].Key < entries[j].Key
+	})
+
+	total := len(entries)
+	if limit > 0 && total > limit {
+		entries = entries[:limit]
+	}
+	return entries, total, err
+}
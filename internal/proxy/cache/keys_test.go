@@ -0,0 +1,65 @@
+package proxycache
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheHandlerKeys(t *testing.T) {
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		if r.URL.Path == "/posts/2" {
+			w.Header().Set("Vary", "Accept-Language")
+		}
+		_, _ = w.Write([]byte(r.URL.Path))
+	})
+
+	caches := map[string]func(t *testing.T) Cache{
+		"memory": func(t *testing.T) Cache { return NewMemoryCache(1<<20, 1<<10) },
+		"disk": func(t *testing.T) Cache {
+			cache, err := NewDiskCache(t.TempDir(), 1<<20, 1<<10)
+			require.NoError(t, err)
+			return cache
+		},
+		"redis": func(t *testing.T) Cache {
+			cache, _ := newTestRedisCache(t, RedisCacheOptions{MaxItemSize: 1 << 10})
+			return cache
+		},
+	}
+	for name, newCache := range caches {
+		t.Run(name, func(t *testing.T) {
+			h := NewCacheHandler(newCache(t), 1024, origin)
+			for _, url := range []string{"http://example.com/posts/2", "http://example.com/posts/1?b=2&a=1", "http://example.com/about"} {
+				require.Equal(t, "miss", cacheStatus(h, url))
+			}
+
+			entries, total, err := h.Keys(PurgeFilter{}, 0)
+			require.NoError(t, err)
+			assert.Equal(t, 3, total)
+			require.Len(t, entries, 3)
+			assert.Equal(t, "example.com/about", entries[0].URL)
+			assert.Equal(t, "example.com/posts/1?a=1&b=2", entries[1].URL)
+			assert.Equal(t, "example.com/posts/2", entries[2].URL)
+			assert.Equal(t, http.Header{"Accept-Language": {""}}, entries[2].VariantHeader)
+			assert.Positive(t, entries[0].Size)
+			assert.InDelta(t, 60*time.Second, entries[0].TTL, float64(2*time.Second))
+
+			entries, total, err = h.Keys(PurgeFilter{Prefix: "/posts/"}, 1)
+			require.NoError(t, err)
+			assert.Equal(t, 2, total)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "example.com/posts/1?a=1&b=2", entries[0].URL)
+
+			_, err = h.Purge(PurgeFilter{All: true})
+			require.NoError(t, err)
+			entries, total, err = h.Keys(PurgeFilter{}, 0)
+			require.NoError(t, err)
+			assert.Zero(t, total)
+			assert.Empty(t, entries)
+		})
+	}
+}
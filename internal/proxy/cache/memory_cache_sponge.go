@@ -2,47 +2,86 @@ package proxycache
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/dgraph-io/ristretto"
 
-	spongecache "github.com/go-dev-frame/sponge/pkg/cache"
 	"github.com/go-dev-frame/sponge/pkg/logger"
 )
 
+const (
+	// sponge's defaults for the ristretto cache it creates
+	defaultMemoryNumCounters = 1e7
+	defaultMemoryMaxCost     = 1 << 30
+	memoryBufferItems        = 64
+)
+
 // GetCurrentTime allows overriding time in tests.
 type GetCurrentTime func() time.Time
 
-// MemoryCache provides a cache implementation backed by sponge's ristretto cache.
+// memoryEntry is what the side index knows about a stored item.
+type memoryEntry struct {
+	size int
+	meta entryMeta
+}
+
+// MemoryCache provides a cache implementation backed by a ristretto cache
+// configured the way sponge configures its memory cache. Ristretto cannot
+// list its keys, so a side index of the stored keys is kept in step through
+// its eviction callbacks; it backs listing, purges and the memory metrics.
 type MemoryCache struct {
 	client         *ristretto.Cache
 	capacity       int
 	maxItemSize    int
 	getCurrentTime GetCurrentTime
+	// name labels the metrics of this cache
+	name string
+
+	mu      sync.Mutex
+	entries map[CacheKey]memoryEntry
+	bytes   int64
 }
 
 // NewMemoryCache constructs a memory cache bounded by capacity and per-item size.
 func NewMemoryCache(capacity, maxItemSize int) *MemoryCache {
-	opts := []spongecache.Option{}
+	c := &MemoryCache{
+		capacity:       capacity,
+		maxItemSize:    maxItemSize,
+		getCurrentTime: time.Now,
+		name:           "memory",
+		entries:        make(map[CacheKey]memoryEntry),
+	}
+
+	config := &ristretto.Config{
+		NumCounters: defaultMemoryNumCounters,
+		MaxCost:     defaultMemoryMaxCost,
+		BufferItems: memoryBufferItems,
+		OnEvict:     c.onEvict,
+		OnReject:    c.onReject,
+	}
 	if capacity > 0 {
-		opts = append(opts, spongecache.WithMaxCost(int64(capacity)))
+		config.MaxCost = int64(capacity)
 		if numCounters := deriveNumCounters(capacity, maxItemSize); numCounters > 0 {
-			opts = append(opts, spongecache.WithNumCounters(numCounters))
+			config.NumCounters = numCounters
 		}
 	}
 
-	client := spongecache.InitMemory(opts...)
-
-	return &MemoryCache{
-		client:         client,
-		capacity:       capacity,
-		maxItemSize:    maxItemSize,
-		getCurrentTime: time.Now,
+	client, err := ristretto.NewCache(config)
+	if err != nil {
+		panic(err)
 	}
+	c.client = client
+
+	return c
 }
 
 // Set stores a value if it fits per-item limits, leveraging sponge's cache for eviction.
 func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	c.setIndexed(key, value, entryMeta{expiresAt: expiresAt})
+}
+
+func (c *MemoryCache) setIndexed(key CacheKey, value []byte, meta entryMeta) {
 	if c.client == nil {
 		return
 	}
@@ -59,19 +98,23 @@ func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
 	}
 
 	currentTime := c.getCurrentTime()
-	ttl := expiresAt.Sub(currentTime)
+	ttl := meta.expiresAt.Sub(currentTime)
 	if ttl <= 0 {
 		logger.Debug(
 			"proxy cache: item already expired",
 			logger.Any("key", key),
-			logger.Time("expires_at", expiresAt),
+			logger.Time("expires_at", meta.expiresAt),
 		)
 		return
 	}
 
 	valueCopy := append([]byte(nil), value...)
 	ristrettoKey := uint64(key) // ristretto expects built-in numeric types, not custom aliases
+	// indexed first: a rejection is reported while the set is still processed
+	meta.size = itemSize
+	previous, replaced := c.track(key, memoryEntry{size: itemSize, meta: meta})
 	if ok := c.client.SetWithTTL(ristrettoKey, valueCopy, int64(itemSize), ttl); !ok {
+		c.restore(key, previous, replaced)
 		logger.Debug(
 			"proxy cache: failed to store item",
 			logger.Any("key", key),
@@ -85,7 +128,7 @@ func (c *MemoryCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
 		"proxy cache: item stored",
 		logger.Any("key", key),
 		logger.Int("size", itemSize),
-		logger.Time("expires_at", expiresAt),
+		logger.Time("expires_at", meta.expiresAt),
 	)
 }
 
@@ -113,6 +156,129 @@ func (c *MemoryCache) Get(key CacheKey) ([]byte, bool) {
 	return data, true
 }
 
+// Delete removes an item.
+func (c *MemoryCache) Delete(key CacheKey) {
+	if c.client == nil {
+		return
+	}
+	c.client.Del(uint64(key))
+	c.untrack(key)
+}
+
+// Clear removes every item.
+func (c *MemoryCache) Clear() {
+	if c.client == nil {
+		return
+	}
+	// emptied first so the evictions Clear reports are not counted
+	c.mu.Lock()
+	c.entries = make(map[CacheKey]memoryEntry)
+	c.bytes = 0
+	c.updateGauges()
+	c.mu.Unlock()
+
+	c.client.Clear()
+}
+
+// Usage reports how many entries and bytes of values the cache holds.
+func (c *MemoryCache) Usage() (entries int, bytes int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.entries), c.bytes
+}
+
+// Private
+
+// list reads the side index only, so listing neither copies values nor counts
+// as use of them.
+func (c *MemoryCache) list(filter *PurgeFilter, fn func(key CacheKey, meta entryMeta)) error {
+	now := c.getCurrentTime()
+	var keys []CacheKey
+	var metas []entryMeta
+	c.mu.Lock()
+	for key, entry := range c.entries {
+		if entry.meta.expiresAt.After(now) && filter.matches(entry.meta) {
+			keys = append(keys, key)
+			metas = append(metas, entry.meta)
+		}
+	}
+	c.mu.Unlock()
+
+	for i, key := range keys {
+		fn(key, metas[i])
+	}
+	return nil
+}
+
+func (c *MemoryCache) count() (int, error) {
+	entries, _ := c.Usage()
+	return entries, nil
+}
+
+// track indexes an entry and returns the one it replaces.
+func (c *MemoryCache) track(key CacheKey, entry memoryEntry) (memoryEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	previous, replaced := c.entries[key]
+	c.bytes += int64(entry.size - previous.size)
+	c.entries[key] = entry
+	c.updateGauges()
+	return previous, replaced
+}
+
+// restore undoes track for a set that did not happen.
+func (c *MemoryCache) restore(key CacheKey, previous memoryEntry, replaced bool) {
+	if replaced {
+		c.track(key, previous)
+		return
+	}
+	c.untrack(key)
+}
+
+func (c *MemoryCache) untrack(key CacheKey) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return false
+	}
+	delete(c.entries, key)
+	c.bytes -= int64(entry.size)
+	c.updateGauges()
+	return true
+}
+
+// updateGauges publishes the index totals. c.mu must be held.
+func (c *MemoryCache) updateGauges() {
+	memoryBytes.WithLabelValues(c.name).Set(float64(c.bytes))
+	memoryEntries.WithLabelValues(c.name).Set(float64(len(c.entries)))
+}
+
+// onEvict runs on ristretto's goroutine when the policy makes room for a new
+// item or an expired item is cleaned up.
+func (c *MemoryCache) onEvict(item *ristretto.Item) {
+	if !c.untrack(CacheKey(item.Key)) {
+		return
+	}
+	reason := evictionCapacity
+	if !item.Expiration.IsZero() && !item.Expiration.After(time.Now()) {
+		reason = evictionExpired
+	}
+	memoryEvictions.WithLabelValues(c.name, reason).Inc()
+}
+
+// onReject runs when the admission policy turns a new item down. An earlier
+// copy of the key may still be stored if two sets raced.
+func (c *MemoryCache) onReject(item *ristretto.Item) {
+	if _, ok := c.client.GetTTL(item.Key); ok {
+		return
+	}
+	c.untrack(CacheKey(item.Key))
+}
+
 // deriveNumCounters sizes ristretto's frequency sketch so metadata overhead scales with the cache capacity.
 func deriveNumCounters(capacity, maxItemSize int) int64 {
 	if capacity <= 0 {
@@ -0,0 +1,152 @@
+package proxycache
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// hosts beyond this many are counted under otherHost so arbitrary Host
+	// headers cannot grow the label sets without bound
+	maxMetricHosts = 256
+	otherHost      = "other"
+
+	evictionCapacity = "capacity"
+	evictionExpired  = "expired"
+)
+
+// Metrics are registered with the default registry and served on /metrics when
+// app.enableMetrics is on.
+var (
+	cacheRequests = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "requests_total",
+			Help:      "Total number of proxied requests by host and X-Cache result (hit, miss, stale, revalidated, bypass).",
+		}, []string{"host", "result"},
+	)
+
+	cacheStores = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "stores_total",
+			Help:      "Total number of responses written to the cache by host.",
+		}, []string{"host"},
+	)
+
+	cacheHitRatio = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "hit_ratio",
+			Help:      "Share of cacheable requests answered from the cache (hit, stale or revalidated) by host.",
+		}, []string{"host"},
+	)
+
+	memoryBytes = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "memory_bytes",
+			Help:      "Bytes of cached responses held by the in-memory cache.",
+		}, []string{"cache"},
+	)
+
+	memoryEntries = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "memory_entries",
+			Help:      "Number of cached responses held by the in-memory cache.",
+		}, []string{"cache"},
+	)
+
+	memoryEvictions = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "proxy",
+			Subsystem: "cache",
+			Name:      "evictions_total",
+			Help:      "Total number of entries the in-memory cache dropped, by reason (capacity, expired).",
+		}, []string{"cache", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(cacheRequests, cacheStores, cacheHitRatio, memoryBytes, memoryEntries, memoryEvictions)
+}
+
+// hostCounts tallies the requests of one host for its hit ratio.
+type hostCounts struct {
+	served  uint64
+	lookups uint64
+}
+
+// cacheStats records the X-Cache result of every request the handler serves.
+type cacheStats struct {
+	mu    sync.Mutex
+	hosts map[string]*hostCounts
+}
+
+func newCacheStats() *cacheStats {
+	return &cacheStats{hosts: make(map[string]*hostCounts)}
+}
+
+// record counts the result of a request to host. Bypassed requests never
+// consult the cache and do not move the hit ratio.
+func (s *cacheStats) record(host, result string) {
+	switch result {
+	case cacheStatusHit, cacheStatusMiss, cacheStatusStale, cacheStatusRevalidated, cacheStatusBypass:
+	default:
+		// no response was written
+		return
+	}
+
+	s.mu.Lock()
+	host, counts := s.host(host)
+	var ratio float64
+	if result != cacheStatusBypass {
+		counts.lookups++
+		if result != cacheStatusMiss {
+			counts.served++
+		}
+	}
+	if counts.lookups > 0 {
+		ratio = float64(counts.served) / float64(counts.lookups)
+	}
+	s.mu.Unlock()
+
+	cacheRequests.WithLabelValues(host, result).Inc()
+	if result != cacheStatusBypass {
+		cacheHitRatio.WithLabelValues(host).Set(ratio)
+	}
+}
+
+func (s *cacheStats) recordStore(host string) {
+	s.mu.Lock()
+	host, _ = s.host(host)
+	s.mu.Unlock()
+
+	cacheStores.WithLabelValues(host).Inc()
+}
+
+// host returns the label and counts for host, folding new hosts into
+// otherHost once maxMetricHosts are tracked. s.mu must be held.
+func (s *cacheStats) host(host string) (string, *hostCounts) {
+	host = strings.ToLower(host)
+	if counts, ok := s.hosts[host]; ok {
+		return host, counts
+	}
+	if len(s.hosts) >= maxMetricHosts {
+		host = otherHost
+		if counts, ok := s.hosts[host]; ok {
+			return host, counts
+		}
+	}
+	counts := &hostCounts{}
+	s.hosts[host] = counts
+	return host, counts
+}
@@ -0,0 +1,111 @@
+package proxycache
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/ristretto"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheHandlerRecordsResults(t *testing.T) {
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		_, _ = w.Write([]byte("ok"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+	requests := func(result string) float64 {
+		return testutil.ToFloat64(cacheRequests.WithLabelValues("stats.test", result))
+	}
+	misses, hits, bypasses := requests("miss"), requests("hit"), requests("bypass")
+	stores := testutil.ToFloat64(cacheStores.WithLabelValues("stats.test"))
+
+	require.Equal(t, "miss", cacheStatus(h, "http://stats.test/page"))
+	require.Equal(t, "hit", cacheStatus(h, "http://stats.test/page"))
+	require.Equal(t, "hit", cacheStatus(h, "http://stats.test/page"))
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "http://STATS.test/page", nil))
+	require.Equal(t, "bypass", rr.Header().Get("X-Cache"))
+
+	assert.Equal(t, misses+1, requests("miss"))
+	assert.Equal(t, hits+2, requests("hit"))
+	assert.Equal(t, bypasses+1, requests("bypass"))
+	assert.Equal(t, stores+1, testutil.ToFloat64(cacheStores.WithLabelValues("stats.test")))
+	// bypassed requests do not count against the ratio
+	assert.InDelta(t, 2.0/3.0, testutil.ToFloat64(cacheHitRatio.WithLabelValues("stats.test")), 1e-9)
+}
+
+func TestCacheStatsFoldsExtraHosts(t *testing.T) {
+	stats := newCacheStats()
+	for i := 0; i < maxMetricHosts; i++ {
+		stats.record(fmt.Sprintf("host-%d.test", i), cacheStatusHit)
+	}
+	before := testutil.ToFloat64(cacheRequests.WithLabelValues(otherHost, cacheStatusMiss))
+
+	stats.record("one-too-many.test", cacheStatusMiss)
+	stats.record("host-0.test", cacheStatusHit)
+	stats.record("", "")
+
+	assert.Len(t, stats.hosts, maxMetricHosts+1)
+	assert.Equal(t, before+1, testutil.ToFloat64(cacheRequests.WithLabelValues(otherHost, cacheStatusMiss)))
+	assert.Equal(t, uint64(2), stats.hosts["host-0.test"].served)
+}
+
+func TestMemoryCacheTracksUsage(t *testing.T) {
+	cache := NewMemoryCache(1<<20, 1<<10)
+	expiresAt := time.Now().Add(time.Hour)
+
+	cache.Set(1, []byte("first"), expiresAt)
+	cache.Set(2, []byte("second"), expiresAt)
+	cache.Set(2, []byte("2nd"), expiresAt)
+	cache.Set(3, make([]byte, 2<<10), expiresAt) // too large, never stored
+
+	entries, bytes := cache.Usage()
+	assert.Equal(t, 2, entries)
+	assert.Equal(t, int64(len("first")+len("2nd")), bytes)
+
+	listed := map[CacheKey]int{}
+	require.NoError(t, cache.list(&PurgeFilter{All: true}, func(key CacheKey, meta entryMeta) {
+		listed[key] = meta.size
+	}))
+	assert.Equal(t, map[CacheKey]int{1: len("first"), 2: len("2nd")}, listed)
+
+	cache.Delete(1)
+	entries, bytes = cache.Usage()
+	assert.Equal(t, 1, entries)
+	assert.Equal(t, int64(len("2nd")), bytes)
+
+	cache.Clear()
+	entries, bytes = cache.Usage()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, int64(0), bytes)
+}
+
+func TestMemoryCacheCountsEvictions(t *testing.T) {
+	cache := NewMemoryCache(1<<20, 1<<10)
+	cache.name = "eviction_test"
+	expiresAt := time.Now().Add(time.Hour)
+	cache.Set(1, []byte("capacity"), expiresAt)
+	cache.Set(2, []byte("expired"), expiresAt)
+
+	evictions := func(reason string) float64 {
+		return testutil.ToFloat64(memoryEvictions.WithLabelValues("eviction_test", reason))
+	}
+	capacity, expired := evictions(evictionCapacity), evictions(evictionExpired)
+
+	cache.onEvict(&ristretto.Item{Key: 1})
+	cache.onEvict(&ristretto.Item{Key: 2, Expiration: time.Now().Add(-time.Second)})
+	// entries the index no longer knows, e.g. removed by Clear, are not counted
+	cache.onEvict(&ristretto.Item{Key: 2})
+
+	assert.Equal(t, capacity+1, evictions(evictionCapacity))
+	assert.Equal(t, expired+1, evictions(evictionExpired))
+	entries, _ := cache.Usage()
+	assert.Equal(t, 0, entries)
+	assert.Equal(t, float64(0), testutil.ToFloat64(memoryBytes.WithLabelValues("eviction_test")))
+}
@@ -0,0 +1,251 @@
+package proxycache
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	surrogateKeyHeader = "Surrogate-Key"
+	cacheTagHeader     = "Cache-Tag"
+)
+
+var (
+	// ErrInvalidPurgeFilter is returned for filters that select nothing or
+	// contradict themselves.
+	ErrInvalidPurgeFilter = errors.New("invalid purge filter")
+	// ErrListingUnsupported is returned when the backend cannot list its
+	// entries. Purging everything still works.
+	ErrListingUnsupported = errors.New("cache backend cannot list its entries")
+)
+
+// indexedCache is implemented by backends that keep what each entry is a
+// response to next to it, so purges and listings select entries without reading
+// the stored responses. Where the backend is shared or persistent this includes
+// entries written by other replicas or before a restart.
+type indexedCache interface {
+	Cache
+	// setIndexed stores value like Set, until meta.expiresAt, and keeps meta
+	// for listing it.
+	setIndexed(key CacheKey, value []byte, meta entryMeta)
+	// list calls fn for the unexpired entries matching filter, with the size
+	// of their value set.
+	list(filter *PurgeFilter, fn func(key CacheKey, meta entryMeta)) error
+	// count reports how many entries are stored.
+	count() (int, error)
+}
+
+// PurgeFilter selects the cached responses to remove. Set fields must all
+// match; Tags matches an entry carrying any of them.
+type PurgeFilter struct {
+	// All removes every entry and ignores the other fields.
+	All bool
+	// URL is an absolute URL or a path with an optional query; every method and
+	// variant of it is removed.
+	URL string
+	// Prefix matches the start of the request path.
+	Prefix string
+	// Host matches the request host, case-insensitively.
+	Host string
+	// Tags match the Surrogate-Key and Cache-Tag headers of the responses.
+	Tags []string
+}
+
+// entryMeta describes what a stored response was a response to.
+type entryMeta struct {
+	host    string
+	path    string
+	query   string
+	tags    []string
+	variant http.Header
+	// expires ends freshness, expiresAt the time the entry is stored until
+	expires   time.Time
+	expiresAt time.Time
+	size      int
+}
+
+// storedMeta is the form in which backends persist an entryMeta.
+type storedMeta struct {
+	Host      string
+	Path      string
+	Query     string
+	Tags      []string
+	Variant   http.Header
+	Expires   time.Time
+	ExpiresAt time.Time
+	Size      int
+}
+
+func (m entryMeta) encode() ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(storedMeta{
+		Host:      m.host,
+		Path:      m.path,
+		Query:     m.query,
+		Tags:      m.tags,
+		Variant:   m.variant,
+		Expires:   m.expires,
+		ExpiresAt: m.expiresAt,
+		Size:      m.size,
+	})
+	return buf.Bytes(), err
+}
+
+func decodeEntryMeta(b []byte) (entryMeta, error) {
+	var m storedMeta
+	if err := gob.NewDecoder(bytes.NewReader(b)).Decode(&m); err != nil {
+		return entryMeta{}, err
+	}
+	return entryMeta{
+		host:      m.Host,
+		path:      m.Path,
+		query:     m.Query,
+		tags:      m.Tags,
+		variant:   m.Variant,
+		expires:   m.Expires,
+		expiresAt: m.ExpiresAt,
+		size:      m.Size,
+	}, nil
+}
+
+// Purge removes the cached responses selected by filter and reports how many
+// were removed. When the backend cannot be listed completely the entries found
+// so far are still removed and the error is returned.
+func (h *CacheHandler) Purge(filter PurgeFilter) (int, error) {
+	if err := filter.normalize(&h.rules); err != nil {
+		return 0, err
+	}
+
+	if filter.All {
+		// counted rather than listed, Clear does not need the keys
+		purged, err := h.storedEntries()
+		h.cache.Clear()
+		h.varyIndexMu.Lock()
+		h.varyIndex = make(map[CacheKey][]string)
+		h.varyIndexMu.Unlock()
+
+		logger.Info("proxy cache: purged everything", logger.Int("entries", purged), logger.Err(err))
+		return purged, nil
+	}
+
+	keys, err := h.purgeCandidates(&filter)
+	for _, key := range keys {
+		h.cache.Delete(key)
+	}
+
+	logger.Info("proxy cache: purged entries",
+		logger.String("url", filter.URL),
+		logger.String("prefix", filter.Prefix),
+		logger.String("host", filter.Host),
+		logger.Any("tags", filter.Tags),
+		logger.Int("entries", len(keys)),
+		logger.Err(err))
+	return len(keys), err
+}
+
+func (h *CacheHandler) purgeCandidates(filter *PurgeFilter) ([]CacheKey, error) {
+	indexed, ok := h.cache.(indexedCache)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+
+	var keys []CacheKey
+	err := indexed.list(filter, func(key CacheKey, _ entryMeta) {
+		keys = append(keys, key)
+	})
+	return keys, err
+}
+
+// storedEntries reports how many entries the cache holds.
+func (h *CacheHandler) storedEntries() (int, error) {
+	indexed, ok := h.cache.(indexedCache)
+	if !ok {
+		return 0, ErrListingUnsupported
+	}
+	return indexed.count()
+}
+
+// normalize splits an absolute URL into host and path and canonicalises the
+// query the same way cache keys do.
+func (f *PurgeFilter) normalize(rules *Rules) error {
+	if f.All {
+		return nil
+	}
+	if f.URL == "" && f.Prefix == "" && f.Host == "" && len(f.Tags) == 0 {
+		return fmt.Errorf("%w: set url, prefix, host, tags or all", ErrInvalidPurgeFilter)
+	}
+
+	if f.URL != "" {
+		u, err := url.Parse(f.URL)
+		if err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidPurgeFilter, err)
+		}
+		if u.Host != "" {
+			if f.Host != "" && !strings.EqualFold(f.Host, u.Host) {
+				return fmt.Errorf("%w: url and host do not agree", ErrInvalidPurgeFilter)
+			}
+			f.Host = u.Host
+		}
+		path := u.Path
+		if path == "" {
+			path = "/"
+		}
+		f.URL = path + "?" + rules.normalizeQuery(u.Query())
+	}
+	return nil
+}
+
+func (f *PurgeFilter) matches(meta entryMeta) bool {
+	if f.All {
+		return true
+	}
+	if f.Host != "" && !strings.EqualFold(f.Host, meta.host) {
+		return false
+	}
+	if f.URL != "" && f.URL != meta.path+"?"+meta.query {
+		return false
+	}
+	if f.Prefix != "" && !strings.HasPrefix(meta.path, f.Prefix) {
+		return false
+	}
+	if len(f.Tags) > 0 && !hasAnyTag(meta.tags, f.Tags) {
+		return false
+	}
+	return true
+}
+
+func hasAnyTag(tags, wanted []string) bool {
+	for _, tag := range tags {
+		for _, w := range wanted {
+			if tag == w {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// surrogateKeys collects the tags of a response: Surrogate-Key is space
+// separated as used by Fastly, Cache-Tag comma separated as used by Cloudflare.
+func surrogateKeys(header http.Header) []string {
+	var tags []string
+	for _, value := range header.Values(surrogateKeyHeader) {
+		tags = append(tags, strings.Fields(value)...)
+	}
+	for _, value := range header.Values(cacheTagHeader) {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
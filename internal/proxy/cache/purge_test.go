@@ -0,0 +1,155 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// taggingOrigin answers with the tags given in the "tags" query parameter.
+func taggingOrigin(hits *int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*hits++
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		if tags := r.URL.Query().Get("tags"); tags != "" {
+			w.Header().Set("Surrogate-Key", tags)
+			w.Header().Set("Cache-Tag", "site")
+		}
+		_, _ = w.Write([]byte(r.Host + r.URL.Path))
+	})
+}
+
+func cacheStatus(h http.Handler, url string) string {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	return rr.Header().Get("X-Cache")
+}
+
+var purgeURLs = []string{
+	"http://example.com/posts/1?tags=post-1",
+	"http://example.com/posts/2?tags=post-2+user-7",
+	"http://example.com/users/7?tags=user-7",
+	"http://other.com/posts/1",
+}
+
+func TestCacheHandlerPurge(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter PurgeFilter
+		purged []string
+	}{
+		{"url", PurgeFilter{URL: "http://example.com/posts/1?tags=post-1"}, purgeURLs[:1]},
+		{"url on any host", PurgeFilter{URL: "/posts/1"}, purgeURLs[3:]},
+		{"prefix", PurgeFilter{Prefix: "/posts/"}, []string{purgeURLs[0], purgeURLs[1], purgeURLs[3]}},
+		{"prefix and host", PurgeFilter{Prefix: "/posts/", Host: "EXAMPLE.com"}, purgeURLs[:2]},
+		{"host", PurgeFilter{Host: "other.com"}, purgeURLs[3:]},
+		{"surrogate key", PurgeFilter{Tags: []string{"user-7"}}, purgeURLs[1:3]},
+		{"cache tag", PurgeFilter{Tags: []string{"site"}}, purgeURLs[:3]},
+		{"all", PurgeFilter{All: true}, purgeURLs},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int
+			h := NewCacheHandler(newRecordingCache(), 1024, taggingOrigin(&hits))
+			for _, url := range purgeURLs {
+				require.Equal(t, "miss", cacheStatus(h, url))
+			}
+
+			purged, err := h.Purge(tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.purged), purged)
+
+			for _, url := range purgeURLs {
+				want := "hit"
+				if slices.Contains(tt.purged, url) {
+					want = "miss"
+				}
+				assert.Equal(t, want, cacheStatus(h, url), url)
+			}
+		})
+	}
+}
+
+func TestCacheHandlerPurgeRejectsEmptyFilter(t *testing.T) {
+	var hits int
+	h := NewCacheHandler(newRecordingCache(), 1024, taggingOrigin(&hits))
+
+	_, err := h.Purge(PurgeFilter{})
+	assert.ErrorIs(t, err, ErrInvalidPurgeFilter)
+
+	_, err = h.Purge(PurgeFilter{URL: "http://example.com/", Host: "other.com"})
+	assert.ErrorIs(t, err, ErrInvalidPurgeFilter)
+}
+
+func TestCacheHandlerPurgeNeedsListing(t *testing.T) {
+	var hits int
+	cache := newRecordingCache()
+	h := NewCacheHandler(struct{ Cache }{cache}, 1024, taggingOrigin(&hits))
+	require.Equal(t, "miss", cacheStatus(h, purgeURLs[0]))
+
+	_, err := h.Purge(PurgeFilter{Tags: []string{"post-1"}})
+	assert.ErrorIs(t, err, ErrListingUnsupported)
+	_, _, err = h.Keys(PurgeFilter{}, 0)
+	assert.ErrorIs(t, err, ErrListingUnsupported)
+
+	_, err = h.Purge(PurgeFilter{All: true})
+	require.NoError(t, err)
+	assert.Equal(t, "miss", cacheStatus(h, purgeURLs[0]))
+}
+
+func TestCacheHandlerHidesPurgeTags(t *testing.T) {
+	var hits int
+	h := NewCacheHandler(newRecordingCache(), 1024, taggingOrigin(&hits))
+
+	for range 2 {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, purgeURLs[0], nil))
+		assert.Empty(t, rr.Header().Get("Surrogate-Key"))
+		assert.Empty(t, rr.Header().Get("Cache-Tag"))
+	}
+	assert.Equal(t, 1, hits)
+}
+
+func TestCacheHandlerPurgeFindsEntriesOfOtherInstances(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDiskCache(dir, 1<<20, 1024)
+	require.NoError(t, err)
+	redis, _ := newTestRedisCache(t, RedisCacheOptions{MaxItemSize: 1024})
+
+	for name, backends := range map[string][2]Cache{
+		"disk":  {disk, nil},
+		"redis": {redis, NewRedisCache(redis.client, RedisCacheOptions{MaxItemSize: 1024})},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var hits int
+			writer := NewCacheHandler(backends[0], 1024, taggingOrigin(&hits))
+			for _, url := range purgeURLs {
+				require.Equal(t, "miss", cacheStatus(writer, url))
+			}
+
+			// a handler that stored nothing itself, as after a restart or on another replica
+			purger := backends[1]
+			if purger == nil {
+				purger, err = NewDiskCache(dir, 1<<20, 1024)
+				require.NoError(t, err)
+			}
+			purged, err := NewCacheHandler(purger, 1024, taggingOrigin(&hits)).Purge(PurgeFilter{Tags: []string{"post-1", "post-2"}})
+			require.NoError(t, err)
+			assert.Equal(t, 2, purged)
+
+			_, ok := purger.Get(NewVariant(httptest.NewRequest(http.MethodGet, purgeURLs[0], nil)).CacheKey())
+			assert.False(t, ok)
+			_, ok = purger.Get(NewVariant(httptest.NewRequest(http.MethodGet, purgeURLs[2], nil)).CacheKey())
+			assert.True(t, ok)
+
+			purged, err = NewCacheHandler(purger, 1024, taggingOrigin(&hits)).Purge(PurgeFilter{All: true})
+			require.NoError(t, err)
+			assert.Equal(t, 2, purged)
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package proxycache
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+// isRangeRequest reports whether the request asks for part of the response.
+// Range is only defined for GET.
+func isRangeRequest(r *http.Request) bool {
+	return r.Method == http.MethodGet && r.Header.Get("Range") != ""
+}
+
+func stripRange(header http.Header) {
+	header.Del("Range")
+	header.Del("If-Range")
+}
+
+// writeRanges answers a Range request from the full response: 206 with one
+// part or a multipart/byteranges body, 416 when no range can be satisfied,
+// and the full 200 when If-Range does not match.
+func (c *CacheableResponse) writeRanges(w http.ResponseWriter, r *http.Request, cacheStatus string) {
+	c.setHeaders(w, cacheStatus)
+	// ServeContent sets the length of what it sends
+	w.Header().Del("Content-Length")
+	if _, ok := w.Header()["Content-Type"]; !ok {
+		// keep ServeContent from sniffing a type the upstream did not send
+		w.Header()["Content-Type"] = nil
+	}
+
+	modtime, _ := http.ParseTime(c.HttpHeader.Get("Last-Modified"))
+	http.ServeContent(w, r, "", modtime, bytes.NewReader(c.Body))
+}
+
+// fillRange answers a Range request that missed by fetching the full response,
+// caching it and serving the range from it. A response that is too large to
+// hold is abandoned and the original request passed through.
+func (h *CacheHandler) fillRange(w http.ResponseWriter, r *http.Request, variant *Variant, baseKey CacheKey) {
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	full := r.Clone(ctx)
+	stripRange(full.Header)
+
+	sink := &fillWriter{header: http.Header{}, limit: h.maxBodySize, abandon: cancel}
+	cr := h.newCacheableResponse(sink, r)
+	h.next.ServeHTTP(cr, full)
+
+	if sink.abandoned || cr.stasher.Overflowed() {
+		logger.Debug("proxy cache: response too large to fill range, passing through", logger.String("path", r.URL.Path), logger.Int("max_body_size", h.maxBodySize))
+		w.Header().Set("X-Cache", cacheStatusBypass)
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	cr.Body = cr.stasher.Body()
+	h.store(r, variant, baseKey, cr)
+	cr.writeCached(w, r, cacheStatusMiss)
+}
+
+// fillWriter receives the full response fetched for a range; it stops the
+// fetch as soon as the body exceeds what the cache would hold.
+type fillWriter struct {
+	header    http.Header
+	limit     int
+	written   int
+	abandon   context.CancelFunc
+	abandoned bool
+}
+
+func (w *fillWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *fillWriter) WriteHeader(int) {}
+
+func (w *fillWriter) Write(p []byte) (int, error) {
+	w.written += len(p)
+	if w.written > w.limit && !w.abandoned {
+		w.abandoned = true
+		w.abandon()
+	}
+	return len(p), nil
+}
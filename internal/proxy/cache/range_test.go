@@ -0,0 +1,144 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const rangeBody = "abcdefghijklmnopqrstuvwxyz"
+
+// rangeOrigin serves rangeBody, honouring Range itself like Rails' send_file.
+func rangeOrigin(ranges *[]string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		*ranges = append(*ranges, r.Header.Get("Range"))
+		w.Header().Set("Cache-Control", "public, max-age=60")
+		w.Header().Set("Etag", `"alphabet"`)
+		w.Header().Set("Content-Type", "application/pdf")
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(rangeBody))
+	})
+}
+
+func requestRange(h http.Handler, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/file.pdf", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestCacheHandlerServesRangesFromCachedResponse(t *testing.T) {
+	var ranges []string
+	h := NewCacheHandler(newRecordingCache(), 1024, rangeOrigin(&ranges))
+	require.Equal(t, "miss", requestRange(h, nil).Header().Get("X-Cache"))
+
+	rr := requestRange(h, map[string]string{"Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "hit", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "bytes 0-3/26", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "4", rr.Header().Get("Content-Length"))
+	assert.Equal(t, "abcd", rr.Body.String())
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=-3"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "xyz", rr.Body.String())
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=0-1,24-"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.True(t, strings.HasPrefix(rr.Header().Get("Content-Type"), "multipart/byteranges; boundary="))
+	assert.Contains(t, rr.Body.String(), "Content-Range: bytes 0-1/26")
+	assert.Contains(t, rr.Body.String(), "Content-Range: bytes 24-25/26")
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=100-200"})
+	assert.Equal(t, http.StatusRequestedRangeNotSatisfiable, rr.Code)
+	assert.Equal(t, "bytes */26", rr.Header().Get("Content-Range"))
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=0-3", "If-Range": `"alphabet"`})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "abcd", rr.Body.String())
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=0-3", "If-Range": `"changed"`})
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, rangeBody, rr.Body.String())
+
+	assert.Equal(t, []string{""}, ranges)
+}
+
+func TestCacheHandlerPassesRangeMissesThrough(t *testing.T) {
+	var ranges []string
+	h := NewCacheHandler(newRecordingCache(), 1024, rangeOrigin(&ranges))
+
+	rr := requestRange(h, map[string]string{"Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bypass", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "abcd", rr.Body.String())
+
+	// the partial response was not stored
+	assert.Equal(t, "miss", requestRange(h, nil).Header().Get("X-Cache"))
+	assert.Equal(t, []string{"bytes=0-3", ""}, ranges)
+}
+
+func TestCacheHandlerFillsRangeMisses(t *testing.T) {
+	var ranges []string
+	h := NewCacheHandler(newRecordingCache(), 1024, rangeOrigin(&ranges), WithRangeFill(true))
+
+	rr := requestRange(h, map[string]string{"Range": "bytes=4-7"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "efgh", rr.Body.String())
+
+	rr = requestRange(h, map[string]string{"Range": "bytes=8-9"})
+	assert.Equal(t, "hit", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "ij", rr.Body.String())
+	assert.Equal(t, []string{""}, ranges)
+}
+
+func TestCacheHandlerRangeFillGivesUpOnLargeResponses(t *testing.T) {
+	var ranges []string
+	h := NewCacheHandler(newRecordingCache(), 10, rangeOrigin(&ranges), WithRangeFill(true))
+
+	rr := requestRange(h, map[string]string{"Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "bypass", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "abcd", rr.Body.String())
+	assert.Equal(t, []string{"", "bytes=0-3"}, ranges)
+}
+
+func TestCacheHandlerAnswersRangeFromRefetchedResponse(t *testing.T) {
+	var ranges []string
+	version := 0
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		version++
+		body, maxAge := rangeBody, "0"
+		if version > 1 {
+			body, maxAge = strings.ToUpper(rangeBody), "60"
+		}
+		w.Header().Set("Cache-Control", "public, max-age="+maxAge)
+		w.Header().Set("Etag", `"v`+strconv.Itoa(version)+`"`)
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(body))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+	require.Equal(t, "miss", requestRange(h, nil).Header().Get("X-Cache"))
+
+	// the expired entry changed upstream, so the full response comes back
+	rr := requestRange(h, map[string]string{"Range": "bytes=4-7"})
+	assert.Equal(t, http.StatusPartialContent, rr.Code)
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "bytes 4-7/26", rr.Header().Get("Content-Range"))
+	assert.Equal(t, "EFGH", rr.Body.String())
+
+	// and was stored in full
+	rr = requestRange(h, nil)
+	assert.Equal(t, "hit", rr.Header().Get("X-Cache"))
+	assert.Equal(t, strings.ToUpper(rangeBody), rr.Body.String())
+	assert.Equal(t, []string{"", ""}, ranges)
+}
@@ -0,0 +1,439 @@
+package proxycache
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	defaultRedisKeyPrefix = "thrust:proxycache"
+	defaultRedisTimeout   = time.Second
+	defaultRedisL1TTL     = 10 * time.Second
+	redisExpiryHeaderSize = 8
+	redisScanCount        = 500
+
+	// redisInvalidateAll is published instead of a key when the cache is cleared
+	redisInvalidateAll = "*"
+)
+
+// redisIndexScript adds ARGV[2] to the sorted set KEYS[1], scored by the unix
+// milliseconds ARGV[1] it expires at, drops members expired by ARGV[4] and
+// keeps the set for at least the ARGV[3] milliseconds the new member lives.
+// One key per call keeps it usable with Redis Cluster.
+var redisIndexScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[4])
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+if redis.call('PTTL', KEYS[1]) < tonumber(ARGV[3]) then
+	redis.call('PEXPIRE', KEYS[1], ARGV[3])
+end
+return 0
+`)
+
+// RedisCacheOptions configures a RedisCache.
+type RedisCacheOptions struct {
+	// KeyPrefix namespaces the entries so several applications can share a Redis.
+	KeyPrefix string
+	// MaxItemSize bounds the size of a single entry.
+	MaxItemSize int
+	// Timeout bounds every Redis round trip; a slow Redis counts as a miss.
+	Timeout time.Duration
+	// L1Capacity, when positive, keeps a local in-memory copy of entries read or
+	// written by this replica, up to that many bytes.
+	L1Capacity int
+	// L1TTL bounds how long a local copy is served without asking Redis again,
+	// which is how long entries replaced by other replicas, or deleted while
+	// this replica was disconnected from Redis, may take to show up. Deletions
+	// are otherwise published to every replica right away.
+	L1TTL time.Duration
+}
+
+// RedisCache shares cached responses between replicas through Redis, with an
+// optional local in-memory cache in front of it. Each value is stored with its
+// expiry so local copies never outlive the shared entry, and deletions are
+// published on a channel so every replica drops its local copy.
+//
+// Purges and listings do not read the values: the metadata of each entry is
+// stored under its own key, and sorted sets of the entries, in all, by host and
+// by tag, expire along with their last entry.
+type RedisCache struct {
+	client         redis.UniversalClient
+	opts           RedisCacheOptions
+	l1             *MemoryCache
+	invalidations  *redis.PubSub
+	getCurrentTime GetCurrentTime
+}
+
+// NewRedisCache constructs a cache on top of the given Redis client.
+func NewRedisCache(client redis.UniversalClient, opts RedisCacheOptions) *RedisCache {
+	if opts.KeyPrefix == "" {
+		opts.KeyPrefix = defaultRedisKeyPrefix
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRedisTimeout
+	}
+	if opts.L1TTL <= 0 {
+		opts.L1TTL = defaultRedisL1TTL
+	}
+
+	c := &RedisCache{client: client, opts: opts, getCurrentTime: time.Now}
+	if opts.L1Capacity > 0 {
+		c.l1 = NewMemoryCache(opts.L1Capacity, opts.MaxItemSize)
+		c.l1.name = "redis_l1"
+		c.subscribe()
+	}
+	return c
+}
+
+// Close stops listening for invalidations from other replicas.
+func (c *RedisCache) Close() error {
+	if c.invalidations == nil {
+		return nil
+	}
+	return c.invalidations.Close()
+}
+
+// Set stores a value in Redis with a TTL matching expiresAt, and locally.
+func (c *RedisCache) Set(key CacheKey, value []byte, expiresAt time.Time) {
+	c.setIndexed(key, value, entryMeta{expiresAt: expiresAt})
+}
+
+func (c *RedisCache) setIndexed(key CacheKey, value []byte, meta entryMeta) {
+	if len(value) > c.opts.MaxItemSize {
+		logger.Debug("proxy cache: item too large", logger.Int("item_size", len(value)), logger.Int("max_item_size", c.opts.MaxItemSize))
+		return
+	}
+	now := c.getCurrentTime()
+	expiresAt := meta.expiresAt
+	ttl := expiresAt.Sub(now)
+	if ttl <= 0 {
+		logger.Debug("proxy cache: item already expired", logger.Any("key", key), logger.Time("expires_at", expiresAt))
+		return
+	}
+
+	meta.size = len(value)
+	encodedMeta, err := meta.encode()
+	if err != nil {
+		logger.Warn("proxy cache: failed to encode redis entry metadata", logger.Any("key", key), logger.Err(err))
+		return
+	}
+	payload := make([]byte, redisExpiryHeaderSize+len(value))
+	binary.BigEndian.PutUint64(payload, uint64(expiresAt.UnixNano()))
+	copy(payload[redisExpiryHeaderSize:], value)
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	_, err = c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, c.redisKey(key), payload, ttl)
+		pipe.Set(ctx, c.metaKey(key), encodedMeta, ttl)
+		for _, set := range c.indexSets(meta) {
+			redisIndexScript.Eval(ctx, pipe, []string{set},
+				expiresAt.UnixMilli(), redisMember(key), ttl.Milliseconds(), now.UnixMilli())
+		}
+		return nil
+	})
+	if err != nil {
+		logger.Warn("proxy cache: failed to store item in redis", logger.Any("key", key), logger.Err(err))
+		return
+	}
+
+	c.setLocal(key, value, expiresAt)
+}
+
+// Get serves a local copy when there is one and otherwise reads from Redis.
+// Redis errors are reported as misses so the request still reaches the upstream.
+func (c *RedisCache) Get(key CacheKey) ([]byte, bool) {
+	if c.l1 != nil {
+		if value, ok := c.l1.Get(key); ok {
+			return value, true
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	payload, err := c.client.Get(ctx, c.redisKey(key)).Bytes()
+	if err != nil {
+		if !errors.Is(err, redis.Nil) {
+			logger.Warn("proxy cache: failed to read item from redis", logger.Any("key", key), logger.Err(err))
+		}
+		return nil, false
+	}
+	if len(payload) < redisExpiryHeaderSize {
+		logger.Warn("proxy cache: dropping malformed redis entry", logger.Any("key", key))
+		return nil, false
+	}
+
+	expiresAt := time.Unix(0, int64(binary.BigEndian.Uint64(payload)))
+	value := payload[redisExpiryHeaderSize:]
+	c.setLocal(key, value, expiresAt)
+	return value, true
+}
+
+// Delete removes an entry from Redis and the local copy, and tells the other
+// replicas to drop theirs.
+func (c *RedisCache) Delete(key CacheKey) {
+	if c.l1 != nil {
+		c.l1.Delete(key)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	// the host and tag sets are not told; their members are checked against
+	// the metadata and dropped once expired
+	_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, c.redisKey(key), c.metaKey(key))
+		pipe.ZRem(ctx, c.allKey(), redisMember(key))
+		return nil
+	})
+	if err != nil {
+		logger.Warn("proxy cache: failed to delete item from redis", logger.Any("key", key), logger.Err(err))
+	}
+	c.publish(strconv.FormatUint(uint64(key), 16))
+}
+
+// Clear removes every entry under the key prefix. Keys are deleted one by one
+// in a pipeline, as those of a batch may live in different cluster slots.
+func (c *RedisCache) Clear() {
+	if c.l1 != nil {
+		c.l1.Clear()
+	}
+
+	err := c.scan(func(keys []string) error {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		defer cancel()
+		_, err := c.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Del(ctx, key)
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		logger.Warn("proxy cache: failed to clear redis entries", logger.String("prefix", c.opts.KeyPrefix), logger.Err(err))
+	}
+	c.publish(redisInvalidateAll)
+}
+
+// Private
+
+// list looks the candidates up in the narrowest sorted set the filter allows,
+// then checks their metadata in batches.
+func (c *RedisCache) list(filter *PurgeFilter, fn func(key CacheKey, meta entryMeta)) error {
+	now := c.getCurrentTime()
+	sets := []string{c.allKey()}
+	switch {
+	case filter.All:
+	case len(filter.Tags) > 0:
+		sets = sets[:0]
+		for _, tag := range filter.Tags {
+			sets = append(sets, c.tagKey(tag))
+		}
+	case filter.Host != "":
+		sets = []string{c.hostKey(filter.Host)}
+	}
+
+	seen := make(map[string]bool)
+	var members []string
+	for _, set := range sets {
+		err := c.scanSet(set, now, func(member string) {
+			if !seen[member] {
+				seen[member] = true
+				members = append(members, member)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	for start := 0; start < len(members); start += redisScanCount {
+		batch := members[start:min(start+redisScanCount, len(members))]
+		metaKeys := make([]string, len(batch))
+		keys := make([]CacheKey, len(batch))
+		for i, member := range batch {
+			n, err := strconv.ParseUint(member, 16, 64)
+			if err != nil {
+				continue
+			}
+			keys[i] = CacheKey(n)
+			metaKeys[i] = c.metaKey(keys[i])
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		values, err := c.client.MGet(ctx, metaKeys...).Result()
+		cancel()
+		if err != nil {
+			return err
+		}
+		for i, v := range values {
+			encoded, ok := v.(string)
+			if !ok {
+				continue // deleted or expired since the lookup
+			}
+			meta, err := decodeEntryMeta([]byte(encoded))
+			if err == nil && meta.expiresAt.After(now) && filter.matches(meta) {
+				fn(keys[i], meta)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *RedisCache) count() (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	n, err := c.client.ZCount(ctx, c.allKey(), "("+strconv.FormatInt(c.getCurrentTime().UnixMilli(), 10), "+inf").Result()
+	return int(n), err
+}
+
+// scanSet passes the members of a sorted set that expire after now to fn.
+func (c *RedisCache) scanSet(set string, now time.Time, fn func(member string)) error {
+	var cursor uint64
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+		pairs, next, err := c.client.ZScan(ctx, set, cursor, "", redisScanCount).Result()
+		cancel()
+		if err != nil {
+			return err
+		}
+		// members and their scores alternate
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if score, err := strconv.ParseFloat(pairs[i+1], 64); err == nil && int64(score) > now.UnixMilli() {
+				fn(pairs[i])
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// scan passes the keys under the prefix to fn in batches. SCAN only walks the
+// node it is sent to, so on a cluster every master is scanned, concurrently.
+func (c *RedisCache) scan(fn func(keys []string) error) error {
+	if cluster, ok := c.client.(*redis.ClusterClient); ok {
+		return cluster.ForEachMaster(context.Background(), func(ctx context.Context, node *redis.Client) error {
+			return c.scanNode(ctx, node, fn)
+		})
+	}
+	return c.scanNode(context.Background(), c.client, fn)
+}
+
+func (c *RedisCache) scanNode(ctx context.Context, node redis.Cmdable, fn func(keys []string) error) error {
+	var cursor uint64
+	for {
+		scanCtx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+		keys, next, err := node.Scan(scanCtx, cursor, c.opts.KeyPrefix+":*", redisScanCount).Result()
+		cancel()
+		if err != nil {
+			return err
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+func (c *RedisCache) redisKey(key CacheKey) string {
+	return c.opts.KeyPrefix + ":" + redisMember(key)
+}
+
+func (c *RedisCache) metaKey(key CacheKey) string {
+	return c.opts.KeyPrefix + ":meta:" + redisMember(key)
+}
+
+func (c *RedisCache) allKey() string {
+	return c.opts.KeyPrefix + ":entries"
+}
+
+func (c *RedisCache) hostKey(host string) string {
+	return c.opts.KeyPrefix + ":host:" + strings.ToLower(host)
+}
+
+func (c *RedisCache) tagKey(tag string) string {
+	return c.opts.KeyPrefix + ":tag:" + tag
+}
+
+// indexSets returns the sorted sets an entry is listed in.
+func (c *RedisCache) indexSets(meta entryMeta) []string {
+	sets := []string{c.allKey()}
+	if meta.host != "" {
+		sets = append(sets, c.hostKey(meta.host))
+	}
+	for _, tag := range meta.tags {
+		sets = append(sets, c.tagKey(tag))
+	}
+	return sets
+}
+
+// redisMember names an entry in the sorted sets and its keys.
+func redisMember(key CacheKey) string {
+	return fmt.Sprintf("%016x", uint64(key))
+}
+
+func (c *RedisCache) channel() string {
+	return c.opts.KeyPrefix + ":invalidate"
+}
+
+// subscribe drops local copies as other replicas delete entries. The
+// subscription is restored when the connection to Redis is; deletions
+// published meanwhile are missed and the copies expire after L1TTL.
+func (c *RedisCache) subscribe() {
+	c.invalidations = c.client.Subscribe(context.Background(), c.channel())
+
+	// wait for the subscription so deletions right after startup are not missed
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	if _, err := c.invalidations.Receive(ctx); err != nil {
+		logger.Warn("proxy cache: failed to subscribe to redis invalidations", logger.String("channel", c.channel()), logger.Err(err))
+	}
+
+	messages := c.invalidations.Channel()
+	go func() {
+		for msg := range messages {
+			if msg.Payload == redisInvalidateAll {
+				c.l1.Clear()
+				continue
+			}
+			if key, err := strconv.ParseUint(msg.Payload, 16, 64); err == nil {
+				c.l1.Delete(CacheKey(key))
+			}
+		}
+	}()
+}
+
+// publish tells every replica, including this one, to drop the local copy of
+// an entry or, with redisInvalidateAll, of every entry.
+func (c *RedisCache) publish(payload string) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.opts.Timeout)
+	defer cancel()
+	if err := c.client.Publish(ctx, c.channel(), payload).Err(); err != nil {
+		logger.Warn("proxy cache: failed to publish invalidation", logger.String("channel", c.channel()), logger.Err(err))
+	}
+}
+
+func (c *RedisCache) setLocal(key CacheKey, value []byte, expiresAt time.Time) {
+	if c.l1 == nil {
+		return
+	}
+	if limit := c.getCurrentTime().Add(c.opts.L1TTL); expiresAt.After(limit) {
+		expiresAt = limit
+	}
+	c.l1.Set(key, value, expiresAt)
+}
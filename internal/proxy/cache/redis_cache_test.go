@@ -0,0 +1,166 @@
+package proxycache
+
+import (
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestRedisCache(t *testing.T, opts RedisCacheOptions) (*RedisCache, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { _ = client.Close() })
+
+	cache := NewRedisCache(client, opts)
+	t.Cleanup(func() { _ = cache.Close() })
+	return cache, server
+}
+
+func TestRedisCacheSharesEntriesWithTTL(t *testing.T) {
+	cache, server := newTestRedisCache(t, RedisCacheOptions{KeyPrefix: "app", MaxItemSize: 1024})
+
+	cache.Set(42, []byte("payload"), time.Now().Add(time.Minute))
+
+	redisKey := "app:000000000000002a"
+	require.True(t, server.Exists(redisKey))
+	ttl := server.TTL(redisKey)
+	assert.True(t, ttl > 50*time.Second && ttl <= time.Minute, "ttl = %s", ttl)
+
+	// another replica sharing the same redis sees the entry
+	other := NewRedisCache(redis.NewClient(&redis.Options{Addr: server.Addr()}), RedisCacheOptions{KeyPrefix: "app", MaxItemSize: 1024})
+	value, ok := other.Get(42)
+	require.True(t, ok)
+	assert.Equal(t, "payload", string(value))
+
+	server.FastForward(2 * time.Minute)
+	_, ok = other.Get(42)
+	assert.False(t, ok)
+}
+
+func TestRedisCacheRejectsOversizedItems(t *testing.T) {
+	cache, server := newTestRedisCache(t, RedisCacheOptions{MaxItemSize: 4})
+
+	cache.Set(1, []byte("too large"), time.Now().Add(time.Minute))
+	assert.Empty(t, server.Keys())
+}
+
+func TestRedisCacheServesL1WhileRedisIsDown(t *testing.T) {
+	cache, server := newTestRedisCache(t, RedisCacheOptions{MaxItemSize: 1024, L1Capacity: 1 << 20, L1TTL: time.Minute})
+
+	cache.Set(1, []byte("local"), time.Now().Add(time.Hour))
+	server.Close()
+
+	value, ok := cache.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "local", string(value))
+
+	_, ok = cache.Get(2)
+	assert.False(t, ok, "redis errors are misses")
+}
+
+func TestRedisCacheL1RespectsL1TTL(t *testing.T) {
+	cache, server := newTestRedisCache(t, RedisCacheOptions{MaxItemSize: 1024, L1Capacity: 1 << 20, L1TTL: 100 * time.Millisecond})
+
+	cache.Set(1, []byte("v1"), time.Now().Add(time.Hour))
+	// another replica replaces the entry
+	require.NoError(t, server.Set("thrust:proxycache:0000000000000001", "\x00\x00\x00\x00\x00\x00\x00\x00v2"))
+
+	value, ok := cache.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "v1", string(value), "local copy is served within l1TTL")
+
+	time.Sleep(200 * time.Millisecond)
+	value, ok = cache.Get(1)
+	require.True(t, ok)
+	assert.Equal(t, "v2", string(value))
+}
+
+func TestRedisCacheInvalidatesOtherReplicas(t *testing.T) {
+	opts := RedisCacheOptions{MaxItemSize: 1024, L1Capacity: 1 << 20, L1TTL: time.Hour}
+	cache, server := newTestRedisCache(t, opts)
+	other := NewRedisCache(redis.NewClient(&redis.Options{Addr: server.Addr()}), opts)
+	t.Cleanup(func() { _ = other.Close() })
+
+	expiresAt := time.Now().Add(time.Hour)
+	cache.Set(1, []byte("one"), expiresAt)
+	cache.Set(2, []byte("two"), expiresAt)
+	for _, key := range []CacheKey{1, 2} {
+		_, ok := other.Get(key)
+		require.True(t, ok)
+	}
+
+	cache.Delete(1)
+	assert.Eventually(t, func() bool {
+		_, ok := other.l1.Get(1)
+		return !ok
+	}, time.Second, 10*time.Millisecond, "the other replica keeps its local copy of a deleted entry")
+	_, ok := other.l1.Get(2)
+	assert.True(t, ok)
+
+	cache.Clear()
+	assert.Eventually(t, func() bool {
+		_, ok := other.l1.Get(2)
+		return !ok
+	}, time.Second, 10*time.Millisecond, "the other replica keeps its local copies after a clear")
+}
+
+func TestRedisCacheClearsThroughClusterClient(t *testing.T) {
+	server := miniredis.RunT(t)
+	client := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{server.Addr()}})
+	t.Cleanup(func() { _ = client.Close() })
+	cache := NewRedisCache(client, RedisCacheOptions{KeyPrefix: "app", MaxItemSize: 1024})
+	t.Cleanup(func() { _ = cache.Close() })
+	require.NoError(t, server.Set("other", "kept"))
+
+	expiresAt := time.Now().Add(time.Hour)
+	for key := CacheKey(1); key <= 3; key++ {
+		cache.setIndexed(key, []byte("value"), entryMeta{host: "example.com", tags: []string{"post"}, expiresAt: expiresAt})
+	}
+	require.NotEmpty(t, server.Keys())
+
+	cache.Clear()
+	assert.Equal(t, []string{"other"}, server.Keys())
+	_, ok := cache.Get(1)
+	assert.False(t, ok)
+}
+
+func TestRedisCacheIndexesEntriesApartFromValues(t *testing.T) {
+	cache, server := newTestRedisCache(t, RedisCacheOptions{KeyPrefix: "app", MaxItemSize: 1024})
+	now := time.Now()
+	cache.setIndexed(1, []byte("one"), entryMeta{host: "Example.com", path: "/one", tags: []string{"post"}, expiresAt: now.Add(time.Minute)})
+	cache.setIndexed(2, []byte("two"), entryMeta{host: "example.com", path: "/two", tags: []string{"post"}, expiresAt: now.Add(time.Hour)})
+
+	// the sets live as long as their longest lived entry
+	for _, set := range []string{"app:entries", "app:host:example.com", "app:tag:post"} {
+		members, err := server.ZMembers(set)
+		require.NoError(t, err, set)
+		assert.ElementsMatch(t, []string{"0000000000000001", "0000000000000002"}, members, set)
+		assert.InDelta(t, time.Hour, server.TTL(set), float64(time.Second), set)
+	}
+
+	// values are not needed to select entries
+	server.Del("app:0000000000000001")
+	server.Del("app:0000000000000002")
+	listed := map[CacheKey]int{}
+	require.NoError(t, cache.list(&PurgeFilter{Tags: []string{"post"}, Prefix: "/t"}, func(key CacheKey, meta entryMeta) {
+		listed[key] = meta.size
+	}))
+	assert.Equal(t, map[CacheKey]int{2: len("two")}, listed)
+
+	server.FastForward(2 * time.Minute)
+	cache.getCurrentTime = func() time.Time { return now.Add(2 * time.Minute) }
+	count, err := cache.count()
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+
+	cache.Delete(2)
+	count, err = cache.count()
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
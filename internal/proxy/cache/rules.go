@@ -0,0 +1,138 @@
+package proxycache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Rules customise which requests use the cache and how they are keyed. Name
+// and path patterns use path.Match syntax; a path pattern ending in "/**"
+// matches everything below that prefix.
+type Rules struct {
+	// BypassCookies skips the cache for requests carrying a matching cookie,
+	// such as a session cookie.
+	BypassCookies []string
+	// BypassHeaders skips the cache for requests carrying a matching header.
+	BypassHeaders []string
+	// Paths apply per request path; the first matching rule wins.
+	Paths []PathRule
+	// IgnoreQuery drops matching query parameters, such as "utm_*", from the
+	// key. The remaining parameters are always sorted.
+	IgnoreQuery []string
+	// KeyCookies and KeyHeaders add the values of these request cookies and
+	// headers to every key.
+	KeyCookies []string
+	KeyHeaders []string
+}
+
+// PathRule overrides caching for the paths matching Pattern.
+type PathRule struct {
+	Pattern string
+	// TTL replaces the freshness lifetime the upstream gave the response, and
+	// makes responses without one cacheable. private and no-store still apply.
+	TTL time.Duration
+	// NoCache passes the requests straight through.
+	NoCache bool
+}
+
+// Validate reports malformed patterns.
+func (rules *Rules) Validate() error {
+	patterns := slices.Concat(rules.BypassCookies, rules.BypassHeaders, rules.IgnoreQuery)
+	for _, p := range rules.Paths {
+		patterns = append(patterns, strings.TrimSuffix(p.Pattern, "/**"))
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid cache rule pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// WithRules applies rules to the handler.
+func WithRules(rules Rules) HandlerOption {
+	return func(h *CacheHandler) {
+		h.rules = rules
+	}
+}
+
+// bypassReason returns why the request must not use the cache, or "".
+func (rules *Rules) bypassReason(r *http.Request) string {
+	if rule := rules.pathRule(r.URL.Path); rule != nil && rule.NoCache {
+		return "path " + rule.Pattern
+	}
+	for name := range r.Header {
+		if matchAny(rules.BypassHeaders, name, true) {
+			return "header " + name
+		}
+	}
+	if len(rules.BypassCookies) > 0 {
+		for _, cookie := range r.Cookies() {
+			if matchAny(rules.BypassCookies, cookie.Name, false) {
+				return "cookie " + cookie.Name
+			}
+		}
+	}
+	return ""
+}
+
+// pathRule returns the first rule matching the request path.
+func (rules *Rules) pathRule(requestPath string) *PathRule {
+	for i := range rules.Paths {
+		if matchPath(rules.Paths[i].Pattern, requestPath) {
+			return &rules.Paths[i]
+		}
+	}
+	return nil
+}
+
+// normalizeQuery drops ignored parameters and encodes the rest sorted by key.
+func (rules *Rules) normalizeQuery(query url.Values) string {
+	for name := range query {
+		if matchAny(rules.IgnoreQuery, name, false) {
+			delete(query, name)
+		}
+	}
+	return query.Encode()
+}
+
+// keyComponents returns the configured cookie and header values of the request.
+func (rules *Rules) keyComponents(r *http.Request) []string {
+	var components []string
+	for _, name := range rules.KeyCookies {
+		value := ""
+		if cookie, err := r.Cookie(name); err == nil {
+			value = cookie.Value
+		}
+		components = append(components, "cookie:"+name+"="+value)
+	}
+	for _, name := range rules.KeyHeaders {
+		components = append(components, "header:"+http.CanonicalHeaderKey(name)+"="+r.Header.Get(name))
+	}
+	return components
+}
+
+func matchPath(pattern, requestPath string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "/**"); ok {
+		return requestPath == prefix || strings.HasPrefix(requestPath, prefix+"/")
+	}
+	ok, _ := path.Match(pattern, requestPath)
+	return ok
+}
+
+func matchAny(patterns []string, name string, foldCase bool) bool {
+	for _, pattern := range patterns {
+		if foldCase {
+			pattern, name = strings.ToLower(pattern), strings.ToLower(name)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
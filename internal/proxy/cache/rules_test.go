@@ -0,0 +1,124 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRulesValidate(t *testing.T) {
+	assert.NoError(t, (&Rules{BypassCookies: []string{"_session*"}, Paths: []PathRule{{Pattern: "/assets/**"}}}).Validate())
+	assert.Error(t, (&Rules{IgnoreQuery: []string{"utm_["}}).Validate())
+	assert.Error(t, (&Rules{Paths: []PathRule{{Pattern: "/[a-/**"}}}).Validate())
+}
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"/assets/**", "/assets/app.css", true},
+		{"/assets/**", "/assets/images/logo.png", true},
+		{"/assets/**", "/assets", true},
+		{"/assets/**", "/assetsx/app.css", false},
+		{"/posts/*", "/posts/1", true},
+		{"/posts/*", "/posts/1/comments", false},
+		{"/*.json", "/feed.json", true},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchPath(tt.pattern, tt.path), tt.pattern+" "+tt.path)
+	}
+}
+
+func TestCacheHandlerBypassRules(t *testing.T) {
+	var hits int
+	h := NewCacheHandler(newRecordingCache(), 1024, taggingOrigin(&hits), WithRules(Rules{
+		BypassCookies: []string{"_app_session"},
+		BypassHeaders: []string{"x-csrf-*"},
+		Paths:         []PathRule{{Pattern: "/admin/**", NoCache: true}},
+	}))
+
+	send := func(url string, header map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Header().Get("X-Cache")
+	}
+
+	require.Equal(t, "miss", send("http://example.com/page", nil))
+	assert.Equal(t, "hit", send("http://example.com/page", nil))
+	assert.Equal(t, "bypass", send("http://example.com/page", map[string]string{"Cookie": "theme=dark; _app_session=abc"}))
+	assert.Equal(t, "hit", send("http://example.com/page", map[string]string{"Cookie": "theme=dark"}))
+	assert.Equal(t, "bypass", send("http://example.com/page", map[string]string{"X-CSRF-Token": "abc"}))
+
+	assert.Equal(t, "bypass", send("http://example.com/admin/users", nil))
+	assert.Equal(t, "bypass", send("http://example.com/admin/users", nil))
+	assert.Equal(t, 5, hits)
+}
+
+func TestCacheHandlerKeyRules(t *testing.T) {
+	var hits int
+	h := NewCacheHandler(newRecordingCache(), 1024, taggingOrigin(&hits), WithRules(Rules{
+		IgnoreQuery: []string{"utm_*", "fbclid"},
+		KeyCookies:  []string{"locale"},
+	}))
+
+	send := func(url, cookie string) string {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if cookie != "" {
+			req.Header.Set("Cookie", cookie)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr.Header().Get("X-Cache")
+	}
+
+	require.Equal(t, "miss", send("http://example.com/list?page=2&sort=asc", ""))
+	assert.Equal(t, "hit", send("http://example.com/list?sort=asc&utm_source=mail&page=2&fbclid=x", ""))
+	assert.Equal(t, "miss", send("http://example.com/list?page=3&sort=asc", ""))
+
+	require.Equal(t, "miss", send("http://example.com/list?page=2&sort=asc", "locale=de"))
+	assert.Equal(t, "hit", send("http://example.com/list?page=2&sort=asc", "locale=de; theme=dark"))
+	assert.Equal(t, "hit", send("http://example.com/list?page=2&sort=asc", ""))
+	assert.Equal(t, 3, hits)
+
+	// purging by url uses the same normalisation
+	purged, err := h.Purge(PurgeFilter{URL: "http://example.com/list?utm_campaign=x&sort=asc&page=2"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, purged)
+}
+
+func TestCacheHandlerPathTTL(t *testing.T) {
+	var hits int
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		switch r.URL.Path {
+		case "/assets/app.css":
+			// no caching headers at all
+		case "/assets/private.css":
+			w.Header().Set("Cache-Control", "private")
+		default:
+			w.Header().Set("Cache-Control", "max-age=0")
+		}
+		_, _ = w.Write([]byte("body"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin, WithRules(Rules{
+		Paths: []PathRule{{Pattern: "/assets/**", TTL: time.Hour}, {Pattern: "/news", TTL: time.Minute}},
+	}))
+
+	for _, path := range []string{"/assets/app.css", "/news"} {
+		require.Equal(t, "miss", serveRequest(h, "http://example.com"+path).Header().Get("X-Cache"), path)
+		assert.Equal(t, "hit", serveRequest(h, "http://example.com"+path).Header().Get("X-Cache"), path)
+	}
+
+	serveRequest(h, "http://example.com/assets/private.css")
+	assert.Equal(t, "miss", serveRequest(h, "http://example.com/assets/private.css").Header().Get("X-Cache"))
+	assert.Equal(t, 4, hits)
+}
@@ -0,0 +1,280 @@
+package proxycache
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const defaultRevalidationWindow = time.Hour
+
+// revalidate fetches a fresh copy of the stale entry in the background unless
+// a refresh of it is already running. The client has already been answered.
+func (h *CacheHandler) revalidate(r *http.Request, key CacheKey, stale CacheableResponse) {
+	if _, running := h.revalidating.LoadOrStore(key, struct{}{}); running {
+		return
+	}
+
+	req := r.Clone(context.WithoutCancel(r.Context()))
+	stale.setConditionalHeaders(req.Header)
+	stripRange(req.Header)
+
+	go func() {
+		defer h.revalidating.Delete(key)
+
+		variant := newVariant(req, &h.rules)
+		cr := h.newCacheableResponse(newDiscardResponseWriter(), req)
+		h.next.ServeHTTP(cr, req)
+
+		if cr.StatusCode == http.StatusNotModified && stale.hasValidators() {
+			h.store(req, variant, variant.CacheKey(), stale.refreshed(cr))
+			logger.Debug("proxy cache: stale response still valid", logger.String("path", req.URL.Path), logger.Any("key", key))
+			return
+		}
+		if cacheable, _ := cr.CacheStatus(); !cacheable {
+			logger.Warn("proxy cache: background revalidation did not return a cacheable response",
+				logger.String("path", req.URL.Path),
+				logger.Int("status", cr.StatusCode))
+			return
+		}
+		h.store(req, variant, variant.CacheKey(), cr)
+		logger.Debug("proxy cache: revalidated stale response", logger.String("path", req.URL.Path), logger.Any("key", key))
+	}()
+}
+
+// refetch forwards a request for an expired entry. When the entry has
+// validators the request is made conditional, and a 304 refreshes the entry
+// and answers the client from it. Within the entry's stale-if-error window a
+// 5xx answer, or one that does not arrive within the stale-if-error timeout,
+// is replaced with the expired copy. A Range request is revalidated for the
+// full response, which is stored before the range is answered from it, as
+// fillRange does.
+func (h *CacheHandler) refetch(w http.ResponseWriter, r *http.Request, variant *Variant, baseKey CacheKey, expired *CacheableResponse) {
+	onError := expired.canServeOnError(time.Now())
+	conditional := expired.hasValidators()
+	ranged := isRangeRequest(r)
+
+	guard := &interceptWriter{ResponseWriter: w, header: http.Header{}}
+	guard.intercept = func(statusCode int) bool {
+		return (conditional && statusCode == http.StatusNotModified) ||
+			(onError && isServerError(statusCode)) ||
+			(ranged && statusCode == http.StatusOK)
+	}
+
+	out := r
+	if conditional || ranged {
+		// the client's own validators and ranges are answered from the
+		// refreshed entry
+		out = r.Clone(r.Context())
+		stripRange(out.Header)
+		if conditional {
+			expired.setConditionalHeaders(out.Header)
+		}
+	}
+
+	if ranged || (onError && h.staleIfErrorTimeout > 0) {
+		ctx, cancel := context.WithCancel(out.Context())
+		defer cancel()
+		if ranged {
+			// stop fetching a full response the cache would not hold
+			guard.limit, guard.abandon = h.maxBodySize, cancel
+		}
+		if onError && h.staleIfErrorTimeout > 0 {
+			guard.timer = time.AfterFunc(h.staleIfErrorTimeout, func() {
+				if guard.interceptTimeout() {
+					cancel()
+				}
+			})
+			defer guard.timer.Stop()
+		}
+		out = out.WithContext(ctx)
+	}
+
+	cr := h.newCacheableResponse(guard, r)
+	h.next.ServeHTTP(cr, out)
+
+	status, intercepted := guard.intercepted()
+	switch {
+	case !intercepted:
+		h.store(r, variant, baseKey, cr)
+	case status == http.StatusNotModified:
+		refreshed := expired.refreshed(cr)
+		h.store(r, variant, baseKey, refreshed)
+		refreshed.writeCached(w, r, cacheStatusRevalidated)
+	case status == http.StatusOK:
+		if guard.abandoned || cr.stasher.Overflowed() {
+			logger.Debug("proxy cache: response too large to fill range, passing through", logger.String("path", r.URL.Path), logger.Int("max_body_size", h.maxBodySize))
+			w.Header().Set("X-Cache", cacheStatusBypass)
+			h.next.ServeHTTP(w, r)
+			return
+		}
+		cr.Body = cr.stasher.Body()
+		h.store(r, variant, baseKey, cr)
+		cr.writeCached(w, r, cacheStatusMiss)
+	default:
+		logger.Info("proxy cache: upstream failed, serving stale response",
+			logger.String("path", r.URL.Path),
+			logger.Int("status", status))
+		expired.writeCached(w, r, cacheStatusStale)
+	}
+}
+
+// interceptWriter holds back responses the cache answers itself, such as a
+// 304 to its own conditional request, a server error it has a stale copy for
+// or the full response to a range; any other response passes straight
+// through. Once more than limit bytes of a held back body arrive, abandon is
+// called.
+type interceptWriter struct {
+	http.ResponseWriter
+	header    http.Header
+	intercept func(statusCode int) bool
+	timer     *time.Timer
+	limit     int
+	abandon   context.CancelFunc
+
+	mu          sync.Mutex
+	status      int
+	committed   bool
+	interrupted bool
+	written     int
+	abandoned   bool
+}
+
+func (w *interceptWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *interceptWriter) WriteHeader(statusCode int) {
+	if w.interceptNow(statusCode) {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed || w.interrupted {
+		return
+	}
+	w.status = statusCode
+	w.committed = true
+	if w.timer != nil {
+		w.timer.Stop()
+	}
+	for k, v := range w.header {
+		w.ResponseWriter.Header()[k] = v
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *interceptWriter) Write(p []byte) (int, error) {
+	if !w.isCommitted() {
+		w.WriteHeader(http.StatusOK)
+	}
+	if _, intercepted := w.intercepted(); intercepted {
+		w.discard(len(p))
+		return len(p), nil
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// discard counts a held back write and abandons the response once it grows
+// past the limit.
+func (w *interceptWriter) discard(n int) {
+	if w.abandon == nil {
+		return
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.written += n
+	if w.written > w.limit && !w.abandoned {
+		w.abandoned = true
+		w.abandon()
+	}
+}
+
+func (w *interceptWriter) Flush() {
+	if !w.isCommitted() {
+		return
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// interceptNow holds back the response if nothing was sent yet and statusCode
+// is one the cache answers itself.
+func (w *interceptWriter) interceptNow(statusCode int) bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.interrupted {
+		return true
+	}
+	if w.committed || !w.intercept(statusCode) {
+		return false
+	}
+	w.status = statusCode
+	w.interrupted = true
+	return true
+}
+
+// interceptTimeout answers with the stale copy when the upstream has not
+// responded in time. It reports false if a response was already sent or held
+// back, so a full response being fetched for a range is not cut short.
+func (w *interceptWriter) interceptTimeout() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.committed || w.interrupted {
+		return false
+	}
+	w.status = http.StatusGatewayTimeout
+	w.interrupted = true
+	return true
+}
+
+func (w *interceptWriter) isCommitted() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.committed
+}
+
+func (w *interceptWriter) intercepted() (int, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.status, w.interrupted
+}
+
+func isServerError(statusCode int) bool {
+	switch statusCode {
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// discardResponseWriter is the client of a background revalidation.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func newDiscardResponseWriter() *discardResponseWriter {
+	return &discardResponseWriter{header: http.Header{}}
+}
+
+func (w *discardResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *discardResponseWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (w *discardResponseWriter) WriteHeader(int) {}
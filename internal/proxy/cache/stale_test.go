@@ -0,0 +1,110 @@
+package proxycache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func serveRequest(h http.Handler, url string) *httptest.ResponseRecorder {
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, url, nil))
+	return rr
+}
+
+func TestCacheHandlerServesStaleWhileRevalidating(t *testing.T) {
+	var hits atomic.Int32
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		w.Header().Set("Cache-Control", "public, max-age=0, stale-while-revalidate=60")
+		_, _ = w.Write([]byte(strconv.Itoa(int(n))))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+
+	rr := serveRequest(h, "http://example.com/feed")
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "1", rr.Body.String())
+
+	rr = serveRequest(h, "http://example.com/feed")
+	assert.Equal(t, "stale", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "1", rr.Body.String())
+
+	require.Eventually(t, func() bool { return hits.Load() == 2 }, time.Second, 10*time.Millisecond)
+	require.Eventually(t, func() bool {
+		return serveRequest(h, "http://example.com/feed").Body.String() != "1"
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCacheHandlerServesStaleIfError(t *testing.T) {
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		code := int(status.Load())
+		if code == http.StatusOK {
+			w.Header().Set("Cache-Control", "public, max-age=0, stale-if-error=60")
+		}
+		w.WriteHeader(code)
+		_, _ = w.Write([]byte(http.StatusText(code)))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin)
+
+	rr := serveRequest(h, "http://example.com/page")
+	require.Equal(t, "miss", rr.Header().Get("X-Cache"))
+
+	status.Store(http.StatusBadGateway)
+	rr = serveRequest(h, "http://example.com/page")
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "stale", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "OK", rr.Body.String())
+
+	// responses that are not server errors pass through
+	status.Store(http.StatusNotFound)
+	rr = serveRequest(h, "http://example.com/page")
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+	assert.Equal(t, "miss", rr.Header().Get("X-Cache"))
+}
+
+func TestCacheHandlerServesStaleIfUpstreamTimesOut(t *testing.T) {
+	var hang atomic.Bool
+	origin := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hang.Load() {
+			// as the reverse proxy does when the request is cancelled
+			<-r.Context().Done()
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.Header().Set("Cache-Control", "public, max-age=0, stale-if-error=60")
+		_, _ = w.Write([]byte("cached"))
+	})
+	h := NewCacheHandler(newRecordingCache(), 1024, origin, WithStaleIfErrorTimeout(50*time.Millisecond))
+
+	require.Equal(t, "miss", serveRequest(h, "http://example.com/page").Header().Get("X-Cache"))
+
+	hang.Store(true)
+	started := time.Now()
+	rr := serveRequest(h, "http://example.com/page")
+	assert.Equal(t, "stale", rr.Header().Get("X-Cache"))
+	assert.Equal(t, "cached", rr.Body.String())
+	assert.Less(t, time.Since(started), time.Second)
+}
+
+func TestCacheableResponseStaleWindows(t *testing.T) {
+	cr := NewCacheableResponse(httptest.NewRecorder(), 1024)
+	cr.Header().Set("Cache-Control", "public, max-age=0, stale-while-revalidate=30, stale-if-error=86400")
+
+	cacheable, _ := cr.CacheStatus()
+	assert.True(t, cacheable)
+	swr, sie := cr.staleWindows()
+	assert.Equal(t, 30*time.Second, swr)
+	assert.Equal(t, 24*time.Hour, sie)
+
+	cr.Header().Set("Cache-Control", "public, max-age=0")
+	cacheable, _ = cr.CacheStatus()
+	assert.False(t, cacheable)
+}
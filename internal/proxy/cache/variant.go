@@ -11,6 +11,7 @@ import (
 type Variant struct {
 	r           *http.Request
 	headerNames []string
+	rules       *Rules
 }
 
 // NewVariant builds a variant tracker for the provided request.
@@ -18,6 +19,11 @@ func NewVariant(r *http.Request) *Variant {
 	return &Variant{r: r}
 }
 
+// newVariant builds a variant keyed according to rules.
+func newVariant(r *http.Request, rules *Rules) *Variant {
+	return &Variant{r: r, rules: rules}
+}
+
 // SetResponseHeader inspects the response headers to learn about the Vary configuration.
 func (v *Variant) SetResponseHeader(header http.Header) {
 	v.headerNames = v.parseVaryHeader(header)
@@ -42,9 +48,19 @@ func (v *Variant) CacheKey() CacheKey {
 	hash := fnv.New64()
 	hash.Write([]byte(v.r.Method))
 	hash.Write([]byte(v.r.URL.Path))
-	hash.Write([]byte(v.r.URL.Query().Encode()))
+	if v.rules != nil {
+		hash.Write([]byte(v.rules.normalizeQuery(v.r.URL.Query())))
+	} else {
+		hash.Write([]byte(v.r.URL.Query().Encode()))
+	}
 	hash.Write([]byte(v.r.Host))
 
+	if v.rules != nil {
+		for _, component := range v.rules.keyComponents(v.r) {
+			hash.Write([]byte(component))
+		}
+	}
+
 	for _, name := range v.headerNames {
 		hash.Write([]byte(name + "=" + v.r.Header.Get(name)))
 	}
@@ -0,0 +1,231 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	defaultHealthCheckPath     = "/up"
+	defaultHealthCheckInterval = 5 * time.Second
+	defaultHealthCheckTimeout  = 2 * time.Second
+)
+
+// HealthCheckOptions configures active probing and passive observation of backends.
+type HealthCheckOptions struct {
+	// Path is requested on every backend each Interval; 2xx and 3xx count as healthy.
+	Path     string
+	Interval time.Duration
+	Timeout  time.Duration
+	// FailureThreshold consecutive failures eject a backend from rotation.
+	FailureThreshold int
+	// SuccessThreshold consecutive successful probes reinstate an ejected backend.
+	SuccessThreshold int
+	// Passive counts connection errors and 5xx responses from live traffic as failures.
+	Passive bool
+}
+
+// BackendStatus is a point-in-time view of a backend for health reporting.
+type BackendStatus struct {
+	Name                string    `json:"name"`
+	Healthy             bool      `json:"healthy"`
+	ActiveConnections   int64     `json:"activeConnections"`
+	Requests            uint64    `json:"requests"`
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastError           string    `json:"lastError,omitempty"`
+	LastCheck           time.Time `json:"lastCheck,omitempty"`
+}
+
+// Status returns the current health state of the backend.
+func (b *Backend) Status() BackendStatus {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	return BackendStatus{
+		Name:                b.Name(),
+		Healthy:             b.Healthy(),
+		ActiveConnections:   b.ActiveConnections(),
+		Requests:            b.Requests(),
+		ConsecutiveFailures: b.failures,
+		LastError:           b.lastError,
+		LastCheck:           b.lastCheck,
+	}
+}
+
+// Status returns the health state of every backend in the pool.
+func (p *Pool) Status() []BackendStatus {
+	backends := p.Backends()
+	statuses := make([]BackendStatus, 0, len(backends))
+	for _, backend := range backends {
+		statuses = append(statuses, backend.Status())
+	}
+	return statuses
+}
+
+// Healthy reports whether at least one backend is in rotation.
+func (p *Pool) Healthy() bool {
+	for _, backend := range p.Backends() {
+		if backend.Healthy() {
+			return true
+		}
+	}
+	return false
+}
+
+// HealthChecker periodically probes the backends of a pool and ejects or
+// reinstates them based on consecutive results.
+type HealthChecker struct {
+	pool *Pool
+	opts HealthCheckOptions
+
+	stopOnce sync.Once
+	stop     chan struct{}
+}
+
+// NewHealthChecker attaches a health checker to the pool. Call Start to begin probing.
+func NewHealthChecker(pool *Pool, opts HealthCheckOptions) *HealthChecker {
+	if opts.Path == "" {
+		opts.Path = defaultHealthCheckPath
+	}
+	if opts.Interval <= 0 {
+		opts.Interval = defaultHealthCheckInterval
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultHealthCheckTimeout
+	}
+	if opts.FailureThreshold < 1 {
+		opts.FailureThreshold = 1
+	}
+	if opts.SuccessThreshold < 1 {
+		opts.SuccessThreshold = 1
+	}
+
+	h := &HealthChecker{pool: pool, opts: opts, stop: make(chan struct{})}
+	pool.health = h
+	return h
+}
+
+// Start launches the background probe loop.
+func (h *HealthChecker) Start() {
+	go func() {
+		ticker := time.NewTicker(h.opts.Interval)
+		defer ticker.Stop()
+
+		for {
+			h.probeAll()
+
+			select {
+			case <-h.stop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop ends the probe loop.
+func (h *HealthChecker) Stop() {
+	h.stopOnce.Do(func() {
+		close(h.stop)
+	})
+}
+
+// Private
+
+func (h *HealthChecker) probeAll() {
+	var wg sync.WaitGroup
+	for _, backend := range h.pool.Backends() {
+		wg.Add(1)
+		go func(b *Backend) {
+			defer wg.Done()
+			h.record(b, h.probe(b), true)
+		}(backend)
+	}
+	wg.Wait()
+}
+
+func (h *HealthChecker) probe(b *Backend) error {
+	ctx, cancel := context.WithTimeout(context.Background(), h.opts.Timeout)
+	defer cancel()
+
+	probeURL := *b.target.URL
+	probeURL.Path = h.opts.Path
+	probeURL.RawQuery = ""
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, probeURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := b.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return fmt.Errorf("health check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// record applies a probe or live-traffic result to the backend's counters.
+func (h *HealthChecker) record(b *Backend, err error, active bool) {
+	b.healthMu.Lock()
+	defer b.healthMu.Unlock()
+
+	if active {
+		b.lastCheck = time.Now()
+	}
+
+	if err == nil {
+		b.failures = 0
+		b.successes++
+		if !b.Healthy() && active && b.successes >= h.opts.SuccessThreshold {
+			b.healthy.Store(true)
+			b.lastError = ""
+			logger.Info("upstream target reinstated", logger.String("target", b.Name()), logger.Int("successes", b.successes))
+		}
+		return
+	}
+
+	b.successes = 0
+	b.failures++
+	b.lastError = err.Error()
+	if b.Healthy() && b.failures >= h.opts.FailureThreshold {
+		b.healthy.Store(false)
+		logger.Warn("upstream target ejected",
+			logger.String("target", b.Name()),
+			logger.Int("failures", b.failures),
+			logger.Bool("passive", !active),
+			logger.Err(err))
+	}
+}
+
+// observe feeds live traffic results into the health checker when passive checks are on.
+func (p *Pool) observe(b *Backend, req *http.Request, resp *http.Response, err error) {
+	if p.health == nil || !p.health.opts.Passive {
+		return
+	}
+
+	switch {
+	case err != nil:
+		// A client hanging up says nothing about the backend.
+		if errors.Is(err, context.Canceled) && req.Context().Err() != nil {
+			return
+		}
+		p.health.record(b, err, false)
+	case resp.StatusCode >= http.StatusInternalServerError:
+		p.health.record(b, fmt.Errorf("upstream responded with status %d", resp.StatusCode), false)
+	default:
+		p.health.record(b, nil, false)
+	}
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthCheckerEjectsAndReinstates(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	target, err := ParseTarget(upstream.URL, 0)
+	require.NoError(t, err)
+	pool, err := NewPool([]Target{target}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+
+	checker := NewHealthChecker(pool, HealthCheckOptions{FailureThreshold: 2, SuccessThreshold: 2})
+	backend := pool.Backends()[0]
+
+	checker.probeAll()
+	assert.True(t, backend.Healthy(), "one failure is below the threshold")
+
+	checker.probeAll()
+	assert.False(t, backend.Healthy())
+	assert.False(t, pool.Healthy())
+	assert.Equal(t, 2, backend.Status().ConsecutiveFailures)
+
+	failing.Store(false)
+	checker.probeAll()
+	assert.False(t, backend.Healthy(), "one success is below the threshold")
+
+	checker.probeAll()
+	assert.True(t, backend.Healthy())
+	assert.Empty(t, backend.Status().LastError)
+}
+
+func TestPassiveHealthCheckSkipsEjectedBackends(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer good.Close()
+
+	badTarget, err := ParseTarget(bad.URL, 0)
+	require.NoError(t, err)
+	goodTarget, err := ParseTarget(good.URL, 0)
+	require.NoError(t, err)
+	pool, err := NewPool([]Target{badTarget, goodTarget}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+	NewHealthChecker(pool, HealthCheckOptions{FailureThreshold: 1, Passive: true})
+
+	statuses := map[int]int{}
+	for i := 0; i < 6; i++ {
+		req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+		req.RequestURI = ""
+		resp, err := pool.RoundTrip(req)
+		require.NoError(t, err)
+		_ = resp.Body.Close()
+		statuses[resp.StatusCode]++
+	}
+
+	assert.Equal(t, 1, statuses[http.StatusBadGateway], "bad backend should be ejected after its first 5xx")
+	assert.Equal(t, 5, statuses[http.StatusOK])
+}
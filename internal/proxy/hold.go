@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	holdPollInterval       = 100 * time.Millisecond
+	defaultHoldMaxRequests = 100
+	defaultHoldMaxWait     = 10 * time.Second
+)
+
+// UpstreamStatus is implemented by supervisors that know when the upstream is
+// starting or restarting.
+type UpstreamStatus interface {
+	// Restarting reports whether the upstream is expected to accept connections soon.
+	Restarting() bool
+	// WaitReady blocks until the upstream accepts connections or ctx is done.
+	WaitReady(ctx context.Context) error
+}
+
+// HoldOptions configures holding requests while the upstream refuses connections.
+type HoldOptions struct {
+	Enabled bool
+	// MaxRequests bounds how many requests may be held at once; extra requests
+	// fail straight away.
+	MaxRequests int
+	// MaxWait bounds how long a single request is held.
+	MaxWait time.Duration
+	// Upstream, when set, is consulted to wait for a restart to finish instead
+	// of polling.
+	Upstream UpstreamStatus
+}
+
+// heldError marks a failure that already waited out the hold window, so outer
+// layers do not hold or retry it again.
+type heldError struct {
+	err error
+}
+
+func (e *heldError) Error() string {
+	return e.err.Error()
+}
+
+func (e *heldError) Unwrap() error {
+	return e.err
+}
+
+// holdTransport parks requests that could not connect to the upstream until it
+// accepts connections again, falling back to the original error after MaxWait.
+type holdTransport struct {
+	next  http.RoundTripper
+	opts  HoldOptions
+	slots chan struct{}
+}
+
+func newHoldTransport(next http.RoundTripper, opts HoldOptions) http.RoundTripper {
+	if opts.MaxRequests <= 0 {
+		opts.MaxRequests = defaultHoldMaxRequests
+	}
+	if opts.MaxWait <= 0 {
+		opts.MaxWait = defaultHoldMaxWait
+	}
+
+	return &holdTransport{
+		next:  next,
+		opts:  opts,
+		slots: make(chan struct{}, opts.MaxRequests),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *holdTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body := newReplayableBody(req.Body)
+	out := req
+	if body != nil {
+		out = new(http.Request)
+		*out = *req
+		out.Body = body
+	}
+
+	resp, err := t.next.RoundTrip(out)
+	if err == nil || !isDialError(err) || (body != nil && body.consumed()) {
+		return resp, err
+	}
+
+	select {
+	case t.slots <- struct{}{}:
+		defer func() { <-t.slots }()
+	default:
+		logger.Warn("upstream unavailable and hold queue is full", logger.String("path", req.URL.Path), logger.Int("max_requests", t.opts.MaxRequests))
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(req.Context(), t.opts.MaxWait)
+	defer cancel()
+
+	started := time.Now()
+	logger.Debug("holding request until upstream is available", logger.String("path", req.URL.Path), logger.Err(err))
+
+	for {
+		if waitErr := t.wait(ctx); waitErr != nil {
+			logger.Info("upstream still unavailable after holding request",
+				logger.String("path", req.URL.Path),
+				logger.Duration("waited", time.Since(started)))
+			return nil, &heldError{err: err}
+		}
+
+		resp, err = t.next.RoundTrip(out)
+		if err == nil || !isDialError(err) {
+			logger.Debug("released held request", logger.String("path", req.URL.Path), logger.Duration("waited", time.Since(started)))
+			return resp, err
+		}
+	}
+}
+
+// wait blocks until the next connection attempt is worthwhile.
+func (t *holdTransport) wait(ctx context.Context) error {
+	if t.opts.Upstream != nil && t.opts.Upstream.Restarting() {
+		if err := t.opts.Upstream.WaitReady(ctx); err == nil {
+			return nil
+		}
+	}
+	return sleepContext(ctx, holdPollInterval)
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUpstreamStatus struct {
+	ready chan struct{}
+}
+
+func (f *fakeUpstreamStatus) Restarting() bool {
+	select {
+	case <-f.ready:
+		return false
+	default:
+		return true
+	}
+}
+
+func (f *fakeUpstreamStatus) WaitReady(ctx context.Context) error {
+	select {
+	case <-f.ready:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestReverseProxyHoldsRequestsUntilUpstreamAccepts(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	target, err := ParseTarget("http://"+addr, 0)
+	require.NoError(t, err)
+	pool, err := NewPool([]Target{target}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+
+	status := &fakeUpstreamStatus{ready: make(chan struct{})}
+	handler := NewReverseProxy(Options{
+		Pool: pool,
+		Hold: HoldOptions{Enabled: true, MaxWait: 5 * time.Second, Upstream: status},
+	})
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		restarted, err := net.Listen("tcp", addr)
+		if err != nil {
+			return
+		}
+		server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})}
+		go func() { _ = server.Serve(restarted) }()
+		t.Cleanup(func() { _ = server.Close() })
+		close(status.ready)
+	}()
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusNoContent, rr.Code)
+}
+
+func TestReverseProxyHoldGivesUpAfterMaxWait(t *testing.T) {
+	pool, err := NewPool([]Target{closedTarget(t)}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+
+	handler := NewReverseProxy(Options{
+		Pool: pool,
+		Hold: HoldOptions{Enabled: true, MaxWait: 300 * time.Millisecond},
+	})
+
+	started := time.Now()
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.GreaterOrEqual(t, time.Since(started), 300*time.Millisecond)
+}
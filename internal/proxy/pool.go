@@ -0,0 +1,359 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Load balancing strategies understood by NewPool.
+const (
+	StrategyRoundRobin       = "round-robin"
+	StrategyLeastConnections = "least-connections"
+	StrategyWeighted         = "weighted"
+)
+
+var errNoTargets = errors.New("no upstream targets configured")
+
+// Target describes a single upstream endpoint. Only the scheme and host differ
+// between targets of a pool; the path of the first target is used as the
+// rewrite prefix for every request.
+type Target struct {
+	URL *url.URL
+	// UnixSocketPath, if non-empty, makes the transport dial this socket instead
+	// of URL.Host. URL is still used for the request scheme and Host header.
+	UnixSocketPath string
+	// Weight is only consulted by the weighted strategy; values below 1 count as 1.
+	Weight int
+}
+
+// ParseTarget turns a configured target string into a Target. Accepted forms are
+// http(s) URLs and unix sockets ("unix:///tmp/puma.sock" or "/tmp/puma.sock").
+func ParseTarget(raw string, weight int) (Target, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return Target{}, errors.New("empty target")
+	}
+
+	if strings.HasPrefix(s, "unix://") || strings.HasPrefix(s, "/") {
+		return Target{
+			URL:            &url.URL{Scheme: "http", Host: "localhost"},
+			UnixSocketPath: normalizeUnixSocketPath(s),
+			Weight:         weight,
+		}, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return Target{}, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return Target{}, fmt.Errorf("target %q must be an http(s) url or unix socket", raw)
+	}
+
+	return Target{URL: u, Weight: weight}, nil
+}
+
+// Backend is the runtime state of a Target inside a Pool.
+type Backend struct {
+	target    Target
+	transport http.RoundTripper
+	active    atomic.Int64
+	requests  atomic.Uint64
+	healthy   atomic.Bool
+
+	// currentWeight is guarded by Pool.mu and drives smooth weighted round robin.
+	currentWeight int
+
+	healthMu  sync.Mutex
+	failures  int
+	successes int
+	lastError string
+	lastCheck time.Time
+}
+
+func newBackend(target Target, h2cEnabled bool) *Backend {
+	b := &Backend{
+		target:    target,
+		transport: createProxyTransport(target, h2cEnabled),
+	}
+	b.healthy.Store(true)
+	return b
+}
+
+// Name returns a human readable identifier for logs and status output.
+func (b *Backend) Name() string {
+	if b.target.UnixSocketPath != "" {
+		return "unix://" + b.target.UnixSocketPath
+	}
+	return b.target.URL.Host
+}
+
+// ActiveConnections reports the number of requests currently in flight.
+func (b *Backend) ActiveConnections() int64 {
+	return b.active.Load()
+}
+
+// Requests reports the total number of requests routed to the backend.
+func (b *Backend) Requests() uint64 {
+	return b.requests.Load()
+}
+
+// Healthy reports whether the backend is currently eligible for traffic.
+func (b *Backend) Healthy() bool {
+	return b.healthy.Load()
+}
+
+func (b *Backend) weight() int {
+	if b.target.Weight < 1 {
+		return 1
+	}
+	return b.target.Weight
+}
+
+func (b *Backend) release() {
+	b.active.Add(-1)
+}
+
+// rewrite points the outgoing request at this backend without mutating the original.
+func (b *Backend) rewrite(req *http.Request) *http.Request {
+	out := new(http.Request)
+	*out = *req
+
+	u := *req.URL
+	u.Scheme = b.target.URL.Scheme
+	u.Host = b.target.URL.Host
+	out.URL = &u
+
+	return out
+}
+
+// Pool spreads requests over a set of backends and implements http.RoundTripper.
+type Pool struct {
+	strategy   string
+	h2cEnabled bool
+	// health, when set with passive checks, observes live responses.
+	health *HealthChecker
+
+	mu       sync.Mutex
+	backends []*Backend
+	next     int
+}
+
+// NewPool creates a pool for the targets using the named strategy. An empty
+// strategy selects round-robin.
+func NewPool(targets []Target, strategy string, h2cEnabled bool) (*Pool, error) {
+	if len(targets) == 0 {
+		return nil, errNoTargets
+	}
+
+	switch strategy {
+	case "":
+		strategy = StrategyRoundRobin
+	case StrategyRoundRobin, StrategyLeastConnections, StrategyWeighted:
+	default:
+		return nil, fmt.Errorf("unsupported load balancing strategy %q", strategy)
+	}
+
+	backends, err := newBackends(targets, h2cEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Pool{strategy: strategy, h2cEnabled: h2cEnabled, backends: backends}, nil
+}
+
+// Strategy returns the load balancing strategy in use.
+func (p *Pool) Strategy() string {
+	return p.strategy
+}
+
+// Backends returns a snapshot of the backends in the pool.
+func (p *Pool) Backends() []*Backend {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]*Backend(nil), p.backends...)
+}
+
+// SetTargets atomically replaces the backends of the pool. Requests already in
+// flight finish on the backend they were sent to; idle connections to the
+// replaced backends are closed.
+func (p *Pool) SetTargets(targets []Target) error {
+	if len(targets) == 0 {
+		return errNoTargets
+	}
+
+	backends, err := newBackends(targets, p.h2cEnabled)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	previous := p.backends
+	p.backends = backends
+	p.next = 0
+	p.mu.Unlock()
+
+	for _, backend := range previous {
+		if closer, ok := backend.transport.(interface{ CloseIdleConnections() }); ok {
+			closer.CloseIdleConnections()
+		}
+	}
+	return nil
+}
+
+// RoundTrip selects a backend and forwards the request to it. The backend's
+// connection count is held until the response body is closed.
+func (p *Pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	backend, err := p.acquire()
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := backend.transport.RoundTrip(backend.rewrite(req))
+	p.observe(backend, req, resp, err)
+	if err != nil {
+		backend.release()
+		return nil, err
+	}
+
+	resp.Body = newReleasingBody(resp.Body, backend.release)
+	return resp, nil
+}
+
+// Private
+
+func newBackends(targets []Target, h2cEnabled bool) ([]*Backend, error) {
+	backends := make([]*Backend, 0, len(targets))
+	for _, target := range targets {
+		if target.URL == nil {
+			return nil, errors.New("target url is required")
+		}
+		backends = append(backends, newBackend(target, h2cEnabled))
+	}
+	return backends, nil
+}
+
+func (p *Pool) rewriteURL() *url.URL {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.backends[0].target.URL
+}
+
+// acquire picks the next backend and counts the request against it.
+func (p *Pool) acquire() (*Backend, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.backends) == 0 {
+		return nil, errNoTargets
+	}
+
+	candidates := p.healthyBackends()
+
+	var backend *Backend
+	switch p.strategy {
+	case StrategyLeastConnections:
+		backend = p.pickLeastConnections(candidates)
+	case StrategyWeighted:
+		backend = p.pickWeighted(candidates)
+	default:
+		backend = p.pickRoundRobin(candidates)
+	}
+
+	backend.active.Add(1)
+	backend.requests.Add(1)
+	return backend, nil
+}
+
+// healthyBackends filters out ejected backends. When every backend is ejected
+// the full set is returned so traffic still has a chance to get through.
+func (p *Pool) healthyBackends() []*Backend {
+	healthy := make([]*Backend, 0, len(p.backends))
+	for _, backend := range p.backends {
+		if backend.Healthy() {
+			healthy = append(healthy, backend)
+		}
+	}
+	if len(healthy) == 0 {
+		return p.backends
+	}
+	return healthy
+}
+
+func (p *Pool) pickRoundRobin(candidates []*Backend) *Backend {
+	backend := candidates[p.next%len(candidates)]
+	p.next++
+	return backend
+}
+
+// pickLeastConnections starts scanning at a rotating offset so ties are spread evenly.
+func (p *Pool) pickLeastConnections(candidates []*Backend) *Backend {
+	n := len(candidates)
+	var best *Backend
+	for i := 0; i < n; i++ {
+		candidate := candidates[(p.next+i)%n]
+		if best == nil || candidate.active.Load() < best.active.Load() {
+			best = candidate
+		}
+	}
+	p.next++
+	return best
+}
+
+// pickWeighted implements nginx-style smooth weighted round robin.
+func (p *Pool) pickWeighted(candidates []*Backend) *Backend {
+	total := 0
+	var best *Backend
+	for _, candidate := range candidates {
+		candidate.currentWeight += candidate.weight()
+		total += candidate.weight()
+		if best == nil || candidate.currentWeight > best.currentWeight {
+			best = candidate
+		}
+	}
+	best.currentWeight -= total
+	return best
+}
+
+// releasingBody runs release exactly once when the response body is closed.
+type releasingBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// releasingReadWriteBody preserves io.Writer so protocol upgrades keep working.
+type releasingReadWriteBody struct {
+	releasingBody
+	w io.Writer
+}
+
+func (b *releasingReadWriteBody) Write(p []byte) (int, error) {
+	return b.w.Write(p)
+}
+
+func newReleasingBody(body io.ReadCloser, release func()) io.ReadCloser {
+	if body == nil {
+		release()
+		return nil
+	}
+	if rwc, ok := body.(io.ReadWriteCloser); ok {
+		return &releasingReadWriteBody{releasingBody: releasingBody{ReadCloser: rwc, release: release}, w: rwc}
+	}
+	return &releasingBody{ReadCloser: body, release: release}
+}
@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestPool(t *testing.T, strategy string, weights ...int) *Pool {
+	t.Helper()
+
+	targets := make([]Target, 0, len(weights))
+	for i, weight := range weights {
+		targets = append(targets, Target{
+			URL:    &url.URL{Scheme: "http", Host: fmt.Sprintf("127.0.0.1:%d", 1000*(i+1))},
+			Weight: weight,
+		})
+	}
+
+	pool, err := NewPool(targets, strategy, false)
+	require.NoError(t, err)
+	return pool
+}
+
+func TestParseTarget(t *testing.T) {
+	target, err := ParseTarget("unix:///tmp/puma.sock", 0)
+	require.NoError(t, err)
+	assert.Equal(t, "/tmp/puma.sock", target.UnixSocketPath)
+	assert.Equal(t, "localhost", target.URL.Host)
+
+	target, err = ParseTarget("http://127.0.0.1:3001", 3)
+	require.NoError(t, err)
+	assert.Empty(t, target.UnixSocketPath)
+	assert.Equal(t, "127.0.0.1:3001", target.URL.Host)
+	assert.Equal(t, 3, target.Weight)
+
+	_, err = ParseTarget("ftp://example.com", 0)
+	assert.Error(t, err)
+}
+
+func TestNewPoolRejectsUnknownStrategy(t *testing.T) {
+	_, err := NewPool([]Target{{URL: &url.URL{Scheme: "http", Host: "localhost"}}}, "random", false)
+	assert.Error(t, err)
+
+	_, err = NewPool(nil, StrategyRoundRobin, false)
+	assert.ErrorIs(t, err, errNoTargets)
+}
+
+func TestPoolRoundRobin(t *testing.T) {
+	pool := newTestPool(t, "", 1, 1, 1)
+
+	var picked []string
+	for i := 0; i < 6; i++ {
+		backend, err := pool.acquire()
+		require.NoError(t, err)
+		backend.release()
+		picked = append(picked, backend.Name())
+	}
+
+	assert.Equal(t, picked[:3], picked[3:])
+	assert.ElementsMatch(t, []string{"127.0.0.1:1000", "127.0.0.1:2000", "127.0.0.1:3000"}, picked[:3])
+}
+
+func TestPoolLeastConnections(t *testing.T) {
+	pool := newTestPool(t, StrategyLeastConnections, 1, 1)
+
+	first, err := pool.acquire()
+	require.NoError(t, err)
+
+	for i := 0; i < 3; i++ {
+		second, err := pool.acquire()
+		require.NoError(t, err)
+		assert.NotSame(t, first, second, "busy backend should not be picked while another is idle")
+		second.release()
+	}
+
+	first.release()
+	assert.Equal(t, int64(0), first.ActiveConnections())
+}
+
+func TestPoolWeighted(t *testing.T) {
+	pool := newTestPool(t, StrategyWeighted, 3, 1)
+
+	counts := map[string]int{}
+	for i := 0; i < 8; i++ {
+		backend, err := pool.acquire()
+		require.NoError(t, err)
+		backend.release()
+		counts[backend.Name()]++
+	}
+
+	assert.Equal(t, 6, counts["127.0.0.1:1000"])
+	assert.Equal(t, 2, counts["127.0.0.1:2000"])
+}
+
+func TestPoolRoundTripReleasesOnBodyClose(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer upstream.Close()
+
+	target, err := ParseTarget(upstream.URL, 0)
+	require.NoError(t, err)
+	pool, err := NewPool([]Target{target}, StrategyLeastConnections, false)
+	require.NoError(t, err)
+	backend := pool.Backends()[0]
+
+	req := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	req.RequestURI = ""
+	resp, err := pool.RoundTrip(req)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), backend.ActiveConnections())
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.NoError(t, resp.Body.Close())
+
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int64(0), backend.ActiveConnections())
+	assert.Equal(t, uint64(1), backend.Requests())
+}
+
+func TestPoolSetTargets(t *testing.T) {
+	pool := newTestPool(t, "", 1)
+
+	err := pool.SetTargets([]Target{{URL: &url.URL{Scheme: "http", Host: "127.0.0.1:4000"}}})
+	require.NoError(t, err)
+
+	backends := pool.Backends()
+	require.Len(t, backends, 1)
+	assert.Equal(t, "127.0.0.1:4000", backends[0].Name())
+	assert.Equal(t, "127.0.0.1:4000", pool.rewriteURL().Host)
+
+	assert.ErrorIs(t, pool.SetTargets(nil), errNoTargets)
+	assert.Len(t, pool.Backends(), 1)
+}
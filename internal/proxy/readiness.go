@@ -0,0 +1,39 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readinessGate keeps proxied requests away from an upstream that is still
+// starting. Requests wait up to maxWait for it and are answered with 503
+// Service Unavailable if it does not become ready in time.
+type readinessGate struct {
+	upstream UpstreamStatus
+	maxWait  time.Duration
+	next     http.Handler
+}
+
+// NewReadinessGate wraps next so it only sees requests once upstream is ready.
+func NewReadinessGate(upstream UpstreamStatus, maxWait time.Duration, next http.Handler) http.Handler {
+	return &readinessGate{upstream: upstream, maxWait: maxWait, next: next}
+}
+
+func (g *readinessGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !g.upstream.Restarting() {
+		g.next.ServeHTTP(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), g.maxWait)
+	err := g.upstream.WaitReady(ctx)
+	cancel()
+	if err == nil {
+		g.next.ServeHTTP(w, r)
+		return
+	}
+
+	w.Header().Set("Retry-After", "1")
+	http.Error(w, "upstream is starting", http.StatusServiceUnavailable)
+}
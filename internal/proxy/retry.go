@@ -0,0 +1,240 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	retriesHeader = "X-Proxy-Retries"
+
+	defaultRetryBackoff     = 50 * time.Millisecond
+	defaultRetryMaxBackoff  = time.Second
+	defaultRetryBudgetRatio = 0.2
+	retryBudgetCapacity     = 10
+)
+
+// RetryOptions configures retrying of requests that failed to reach the upstream.
+type RetryOptions struct {
+	// MaxRetries bounds the retries of a single request; zero disables retrying.
+	MaxRetries int
+	// Backoff is the base delay, doubled per attempt and fully jittered.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// BudgetRatio is the number of retries each proxied request earns for the
+	// shared budget, keeping retries to roughly that fraction of traffic.
+	BudgetRatio float64
+}
+
+// retryError reports how many retries were spent before giving up.
+type retryError struct {
+	retries int
+	err     error
+}
+
+func (e *retryError) Error() string {
+	return e.err.Error()
+}
+
+func (e *retryError) Unwrap() error {
+	return e.err
+}
+
+// retryTransport retries requests that are safe to replay on connection failures.
+type retryTransport struct {
+	next   http.RoundTripper
+	opts   RetryOptions
+	budget *retryBudget
+}
+
+func newRetryTransport(next http.RoundTripper, opts RetryOptions) http.RoundTripper {
+	if opts.Backoff <= 0 {
+		opts.Backoff = defaultRetryBackoff
+	}
+	if opts.MaxBackoff <= 0 {
+		opts.MaxBackoff = defaultRetryMaxBackoff
+	}
+	if opts.BudgetRatio <= 0 {
+		opts.BudgetRatio = defaultRetryBudgetRatio
+	}
+
+	return &retryTransport{
+		next:   next,
+		opts:   opts,
+		budget: newRetryBudget(opts.BudgetRatio),
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.budget.deposit()
+
+	body := newReplayableBody(req.Body)
+	for retries := 0; ; retries++ {
+		out := req
+		if body != nil {
+			out = new(http.Request)
+			*out = *req
+			out.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(out)
+		if err == nil {
+			if retries > 0 {
+				resp.Header.Set(retriesHeader, strconv.Itoa(retries))
+			}
+			return resp, nil
+		}
+
+		if retries >= t.opts.MaxRetries || !canRetry(req, body, err) || !t.budget.withdraw() {
+			if retries > 0 {
+				return nil, &retryError{retries: retries, err: err}
+			}
+			return nil, err
+		}
+
+		delay := t.backoff(retries)
+		logger.Debug("retrying upstream request",
+			logger.String("method", req.Method),
+			logger.String("path", req.URL.Path),
+			logger.Int("attempt", retries+1),
+			logger.Duration("backoff", delay),
+			logger.Err(err))
+
+		if err := sleepContext(req.Context(), delay); err != nil {
+			return nil, &retryError{retries: retries, err: err}
+		}
+	}
+}
+
+// backoff returns a fully jittered exponential delay for the given attempt.
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	limit := t.opts.Backoff << attempt
+	if limit <= 0 || limit > t.opts.MaxBackoff {
+		limit = t.opts.MaxBackoff
+	}
+	return rand.N(limit) + 1
+}
+
+// canRetry allows a retry when nothing of the request reached the upstream, or
+// when an idempotent request lost its connection before a response arrived.
+func canRetry(req *http.Request, body *replayableBody, err error) bool {
+	if req.Context().Err() != nil {
+		return false
+	}
+	if body != nil && body.consumed() {
+		return false
+	}
+	var held *heldError
+	if errors.As(err, &held) {
+		return false
+	}
+	if isDialError(err) {
+		return true
+	}
+	return isIdempotent(req) && isConnectionReset(err)
+}
+
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.EPIPE) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return req.Header.Get("Idempotency-Key") != ""
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// replayableBody records whether the upstream transport started reading the
+// request body and keeps the underlying body open across attempts; the HTTP
+// server closes the inbound body once the handler returns.
+type replayableBody struct {
+	body io.ReadCloser
+	read atomic.Bool
+}
+
+func newReplayableBody(body io.ReadCloser) *replayableBody {
+	if body == nil || body == http.NoBody {
+		return nil
+	}
+	return &replayableBody{body: body}
+}
+
+func (b *replayableBody) Read(p []byte) (int, error) {
+	b.read.Store(true)
+	return b.body.Read(p)
+}
+
+func (b *replayableBody) Close() error {
+	return nil
+}
+
+func (b *replayableBody) consumed() bool {
+	return b.read.Load()
+}
+
+// retryBudget is a token bucket shared by all requests: each request earns
+// ratio tokens, each retry spends one.
+type retryBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newRetryBudget(ratio float64) *retryBudget {
+	return &retryBudget{ratio: ratio, tokens: retryBudgetCapacity}
+}
+
+func (b *retryBudget) deposit() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens += b.ratio
+	if b.tokens > retryBudgetCapacity {
+		b.tokens = retryBudgetCapacity
+	}
+}
+
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
@@ -0,0 +1,90 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func closedTarget(t *testing.T) Target {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := ln.Addr().String()
+	require.NoError(t, ln.Close())
+
+	target, err := ParseTarget("http://"+addr, 0)
+	require.NoError(t, err)
+	return target
+}
+
+func TestReverseProxyRetriesDialErrors(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	live, err := ParseTarget(upstream.URL, 0)
+	require.NoError(t, err)
+	pool, err := NewPool([]Target{closedTarget(t), live}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+
+	handler := NewReverseProxy(Options{
+		Pool:  pool,
+		Retry: RetryOptions{MaxRetries: 2, Backoff: time.Millisecond},
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("payload")))
+
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "1", rr.Header().Get(retriesHeader))
+}
+
+func TestReverseProxyReportsRetriesOnFailure(t *testing.T) {
+	pool, err := NewPool([]Target{closedTarget(t)}, StrategyRoundRobin, false)
+	require.NoError(t, err)
+
+	handler := NewReverseProxy(Options{
+		Pool:  pool,
+		Retry: RetryOptions{MaxRetries: 2, Backoff: time.Millisecond},
+	})
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "http://example.com/", nil))
+
+	assert.Equal(t, http.StatusBadGateway, rr.Code)
+	assert.Equal(t, "2", rr.Header().Get(retriesHeader))
+}
+
+func TestCanRetry(t *testing.T) {
+	dialErr := &net.OpError{Op: "dial", Err: assert.AnError}
+	resetErr := &net.OpError{Op: "read", Err: assert.AnError}
+
+	get := httptest.NewRequest(http.MethodGet, "http://example.com/", nil)
+	post := httptest.NewRequest(http.MethodPost, "http://example.com/", strings.NewReader("x"))
+
+	assert.True(t, canRetry(get, nil, dialErr))
+	assert.True(t, canRetry(post, newReplayableBody(post.Body), dialErr))
+	assert.False(t, canRetry(get, nil, resetErr), "non reset errors are not retried")
+
+	sent := newReplayableBody(post.Body)
+	_, _ = sent.Read(make([]byte, 1))
+	assert.False(t, canRetry(post, sent, dialErr), "a partially sent body cannot be replayed")
+
+	budget := newRetryBudget(0.5)
+	for i := 0; i < retryBudgetCapacity; i++ {
+		require.True(t, budget.withdraw())
+	}
+	assert.False(t, budget.withdraw())
+	budget.deposit()
+	budget.deposit()
+	assert.True(t, budget.withdraw())
+}
@@ -9,6 +9,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 
 	"github.com/go-dev-frame/sponge/pkg/logger"
@@ -27,18 +28,38 @@ type Options struct {
 	UnixSocketPath string
 	// H2cEnabled enables HTTP/2 cleartext (h2c) when the upstream speaks it.
 	H2cEnabled bool
+	// Pool, if set, spreads requests over several targets and takes precedence
+	// over TargetURL and UnixSocketPath.
+	Pool *Pool
+	// Retry configures replaying of requests that failed to reach the upstream.
+	Retry RetryOptions
+	// Hold configures queueing of requests while the upstream is unavailable.
+	Hold HoldOptions
 }
 
 // NewReverseProxy builds an httputil.ReverseProxy configured similar to the
 // upstream thruster implementation.
 func NewReverseProxy(opts Options) *httputil.ReverseProxy {
+	pool := opts.Pool
+	if pool == nil {
+		pool = newSingleTargetPool(opts)
+	}
+
+	var transport http.RoundTripper = pool
+	if opts.Hold.Enabled {
+		transport = newHoldTransport(transport, opts.Hold)
+	}
+	if opts.Retry.MaxRetries > 0 {
+		transport = newRetryTransport(transport, opts.Retry)
+	}
+
 	proxy := &httputil.ReverseProxy{
 		Rewrite: func(r *httputil.ProxyRequest) {
-			r.SetURL(opts.TargetURL)
+			r.SetURL(pool.rewriteURL())
 			setXForwarded(r, opts.ForwardHeaders)
 		},
 		ErrorHandler: proxyErrorHandler(opts.BadGatewayPage),
-		Transport:    createProxyTransport(opts),
+		Transport:    transport,
 	}
 
 	return proxy
@@ -54,6 +75,11 @@ func proxyErrorHandler(badGatewayPage string) func(http.ResponseWriter, *http.Re
 	return func(w http.ResponseWriter, r *http.Request, err error) {
 		logger.Info("unable to proxy request", logger.String("path", r.URL.Path), logger.Err(err))
 
+		var retryErr *retryError
+		if errors.As(err, &retryErr) {
+			w.Header().Set(retriesHeader, strconv.Itoa(retryErr.retries))
+		}
+
 		if isRequestEntityTooLarge(err) {
 			w.WriteHeader(http.StatusRequestEntityTooLarge)
 			return
@@ -92,14 +118,22 @@ func isRequestEntityTooLarge(err error) bool {
 	return errors.As(err, &maxBytesError)
 }
 
-func createProxyTransport(opts Options) http.RoundTripper {
+func newSingleTargetPool(opts Options) *Pool {
+	target := Target{URL: opts.TargetURL, UnixSocketPath: opts.UnixSocketPath}
+	return &Pool{
+		strategy: StrategyRoundRobin,
+		backends: []*Backend{newBackend(target, opts.H2cEnabled)},
+	}
+}
+
+func createProxyTransport(target Target, h2cEnabled bool) http.RoundTripper {
 	// Start from the default transport for sane defaults.
 	base := http.DefaultTransport.(*http.Transport).Clone()
 	base.DisableCompression = true
 
 	// If a UNIX socket is provided, always prefer it and keep HTTP/1.1 semantics.
 	// HTTP/2 over unix sockets is uncommon and not targeted here.
-	socketPath := normalizeUnixSocketPath(opts.UnixSocketPath)
+	socketPath := normalizeUnixSocketPath(target.UnixSocketPath)
 	if socketPath != "" {
 		base.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
 			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
@@ -109,7 +143,7 @@ func createProxyTransport(opts Options) http.RoundTripper {
 
 	// Enable HTTP/2 cleartext (h2c) via prior knowledge when explicitly opted-in
 	// and only for non-TLS upstreams.
-	if opts.H2cEnabled && opts.TargetURL != nil && opts.TargetURL.Scheme == "http" {
+	if h2cEnabled && target.URL != nil && target.URL.Scheme == "http" {
 		return &http2.Transport{
 			AllowHTTP:          true,
 			DisableCompression: true,
@@ -0,0 +1,66 @@
+package routers
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-dev-frame/sponge/pkg/gin/response"
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"thrust_oauth2id/internal/config"
+	"thrust_oauth2id/internal/ecode"
+	"thrust_oauth2id/internal/handler"
+)
+
+// registerAdmin mounts the operational endpoints under /admin, guarded by the
+// static bearer token from admin.token.
+func registerAdmin(r *gin.Engine, o *options) {
+	adminCfg := config.Get().Admin
+	if !adminCfg.Enabled {
+		return
+	}
+	if adminCfg.Token == "" {
+		logger.Warn("admin endpoints disabled: admin.token is empty")
+		return
+	}
+
+	g := r.Group("/admin", adminAuth(adminCfg.Token))
+
+	if len(o.upstreams) > 0 {
+		upstreamRouter(g, handler.NewUpstreamHandler(o.upstreams))
+	}
+	if o.cache != nil {
+		cacheRouter(g, handler.NewCacheHandler(o.cache))
+	}
+}
+
+func upstreamRouter(group *gin.RouterGroup, h handler.UpstreamHandler) {
+	g := group.Group("/upstreams")
+
+	g.GET("", h.List)                 // [get] /admin/upstreams
+	g.POST("/:name/reload", h.Reload) // [post] /admin/upstreams/:name/reload
+	g.POST("/:name/signal", h.Signal) // [post] /admin/upstreams/:name/signal
+}
+
+func cacheRouter(group *gin.RouterGroup, h handler.CacheHandler) {
+	g := group.Group("/cache")
+
+	g.POST("/purge", h.Purge) // [post] /admin/cache/purge
+	g.GET("/keys", h.Keys)    // [get] /admin/cache/keys
+}
+
+// adminAuth rejects requests whose bearer token does not match token.
+func adminAuth(token string) gin.HandlerFunc {
+	expected := []byte(token)
+
+	return func(c *gin.Context) {
+		got, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), expected) != 1 {
+			response.Error(c, ecode.Unauthorized)
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package routers
+
+import (
+	"net/http"
+	"os"
+
+	"github.com/gin-gonic/gin"
+
+	"thrust_oauth2id/internal/proxy"
+	"thrust_oauth2id/internal/upstream"
+)
+
+type healthResponse struct {
+	Status    string                `json:"status"`
+	Hostname  string                `json:"hostname"`
+	Upstreams []proxy.BackendStatus `json:"upstreams,omitempty"`
+}
+
+// checkHealth reports the process as UP only while at least one upstream target
+// is in rotation, so orchestrator probes follow upstream readiness. It reports
+// STARTING while no supervised upstream has passed its readiness check yet.
+func checkHealth(pool *proxy.Pool, upstreams upstream.Group) gin.HandlerFunc {
+	hostname, _ := os.Hostname()
+
+	return func(c *gin.Context) {
+		resp := healthResponse{Status: "UP", Hostname: hostname}
+		code := http.StatusOK
+
+		if pool != nil {
+			resp.Upstreams = pool.Status()
+			if !pool.Healthy() {
+				resp.Status = "DOWN"
+				code = http.StatusServiceUnavailable
+			}
+		}
+		if upstreams.Restarting() {
+			resp.Status = "STARTING"
+			code = http.StatusServiceUnavailable
+		}
+
+		c.JSON(code, resp)
+	}
+}
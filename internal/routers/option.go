@@ -0,0 +1,61 @@
+package routers
+
+import (
+	proxcache "thrust_oauth2id/internal/proxy/cache"
+	"thrust_oauth2id/internal/upstream"
+)
+
+// Option setting up the router
+type Option func(*options)
+
+type options struct {
+	upstreams []*upstream.Server
+
+	// cache is set by registerReverseProxy when the proxy cache is enabled.
+	cache *proxcache.CacheHandler
+
+	closes *[]func() error
+}
+
+func defaultOptions() *options {
+	return &options{}
+}
+
+func (o *options) apply(opts ...Option) {
+	for _, opt := range opts {
+		opt(o)
+	}
+}
+
+// WithUpstreams lets the reverse proxy follow the lifecycle of the supervised upstreams
+func WithUpstreams(servers ...*upstream.Server) Option {
+	return func(o *options) {
+		o.upstreams = servers
+	}
+}
+
+// WithCloses collects the functions that stop what the routes started in the
+// background, such as health checks; the caller runs them on shutdown
+func WithCloses(closes *[]func() error) Option {
+	return func(o *options) {
+		o.closes = closes
+	}
+}
+
+// onClose registers fn to run on shutdown.
+func (o *options) onClose(fn func() error) {
+	if o.closes != nil {
+		*o.closes = append(*o.closes, fn)
+	}
+}
+
+// proxyUpstreams returns the supervised upstreams that receive proxied traffic.
+func (o *options) proxyUpstreams() upstream.Group {
+	var group upstream.Group
+	for _, s := range o.upstreams {
+		if s.Proxy() {
+			group = append(group, s)
+		}
+	}
+	return group
+}
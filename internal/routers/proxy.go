@@ -1,87 +1,134 @@
 package routers
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	"net/url"
+	"sync"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-dev-frame/sponge/pkg/logger"
 
 	"thrust_oauth2id/internal/config"
+	"thrust_oauth2id/internal/database"
 	"thrust_oauth2id/internal/proxy"
 	proxcache "thrust_oauth2id/internal/proxy/cache"
+	"thrust_oauth2id/internal/upstream"
 )
 
-func registerReverseProxy(r *gin.Engine) {
+// registerReverseProxy routes unmatched requests to the upstream targets and
+// returns the backing pool, or nil when the proxy is disabled.
+func registerReverseProxy(r *gin.Engine, o *options) *proxy.Pool {
 	cfg := config.Get()
 	proxyCfg := cfg.Proxy
 	if !proxyCfg.Enabled {
-		return
-	}
-
-	targetURLStr := proxyCfg.TargetURL
-	derivedFromSocket := false
-	if targetURLStr == "" {
-		if cfg.Upstream.TargetBindSocket != "" {
-			// When a UNIX socket is configured, we still need a valid HTTP URL
-			// for request rewriting; the transport will dial the socket.
-			targetURLStr = "http://localhost"
-			derivedFromSocket = true
-		} else if cfg.Upstream.Enabled {
-			port := cfg.Upstream.TargetPort
-			if port == 0 {
-				port = 3000
-			}
-			targetURLStr = fmt.Sprintf("http://127.0.0.1:%d", port)
-		}
+		return nil
 	}
 
-	if targetURLStr == "" {
+	upstreams := o.proxyUpstreams()
+	targets, err := proxyTargets(cfg, upstreams)
+	if err != nil {
 		logger.Fatal(
-			"proxy target url not configured",
+			"invalid proxy targets",
 			logger.Bool("proxy_enabled", proxyCfg.Enabled),
-			logger.Bool("upstream_enabled", cfg.Upstream.Enabled),
+			logger.Int("proxy_upstreams", len(upstreams)),
+			logger.Err(err),
 		)
-		return
+		return nil
 	}
 
-	targetURL, err := url.Parse(targetURLStr)
+	pool, err := proxy.NewPool(targets, proxyCfg.LoadBalancing, proxyCfg.H2cEnabled)
 	if err != nil {
-		targetErr := err
-		logger.Fatal("invalid proxy target url", logger.String("target", targetURLStr), logger.Err(targetErr))
-		return
+		logger.Fatal("invalid proxy load balancing", logger.String("strategy", proxyCfg.LoadBalancing), logger.Err(err))
+		return nil
+	}
+
+	// Follow phased restarts of the supervised upstreams when they are the proxy targets.
+	if len(upstreams) > 0 && len(proxyCfg.Targets) == 0 {
+		followUpstreamSwitches(pool, upstreams, targets, proxyCfg.TargetURL)
+	}
+
+	if hc := proxyCfg.HealthCheck; hc.Enabled {
+		checker := proxy.NewHealthChecker(pool, proxy.HealthCheckOptions{
+			Path:             hc.Path,
+			Interval:         time.Duration(hc.Interval) * time.Second,
+			Timeout:          time.Duration(hc.Timeout) * time.Second,
+			FailureThreshold: hc.FailureThreshold,
+			SuccessThreshold: hc.SuccessThreshold,
+			Passive:          hc.Passive,
+		})
+		checker.Start()
+		o.onClose(func() error {
+			checker.Stop()
+			return nil
+		})
+		logger.Info("reverse proxy health checks enabled",
+			logger.String("path", hc.Path),
+			logger.Int("interval", hc.Interval),
+			logger.Int("failure_threshold", hc.FailureThreshold),
+			logger.Int("success_threshold", hc.SuccessThreshold),
+			logger.Bool("passive", hc.Passive),
+		)
 	}
 
-	// Prefer dialing via UNIX socket when the upstream advertises one. This avoids
-	// TCP self-loops when the HTTP server and upstream share a port.
-	var unixSocketPath string
-	if cfg.Upstream.TargetBindSocket != "" && (cfg.Upstream.Enabled || derivedFromSocket) {
-		unixSocketPath = cfg.Upstream.TargetBindSocket
+	var retry proxy.RetryOptions
+	if proxyCfg.Retry.Enabled {
+		retry = proxy.RetryOptions{
+			MaxRetries:  proxyCfg.Retry.MaxRetries,
+			Backoff:     time.Duration(proxyCfg.Retry.Backoff) * time.Millisecond,
+			MaxBackoff:  time.Duration(proxyCfg.Retry.MaxBackoff) * time.Millisecond,
+			BudgetRatio: proxyCfg.Retry.BudgetRatio,
+		}
+	}
+
+	hold := proxy.HoldOptions{
+		Enabled:     proxyCfg.Hold.Enabled,
+		MaxRequests: proxyCfg.Hold.MaxRequests,
+		MaxWait:     time.Duration(proxyCfg.Hold.MaxWait) * time.Second,
+	}
+	if len(upstreams) > 0 {
+		hold.Upstream = upstreams
 	}
 
 	reverseProxy := proxy.NewReverseProxy(proxy.Options{
-		TargetURL:      targetURL,
 		BadGatewayPage: proxyCfg.BadGatewayPage,
 		ForwardHeaders: proxyCfg.ForwardHeaders,
-		UnixSocketPath: unixSocketPath,
-		H2cEnabled:     proxyCfg.H2cEnabled,
+		Pool:           pool,
+		Retry:          retry,
+		Hold:           hold,
 	})
 
-	loggerFields := []logger.Field{
-		logger.String("target", targetURL.String()),
+	targetNames := make([]string, 0, len(targets))
+	for _, backend := range pool.Backends() {
+		targetNames = append(targetNames, backend.Name())
+	}
+
+	logger.Info("reverse proxy enabled",
+		logger.Any("targets", targetNames),
+		logger.String("load_balancing", pool.Strategy()),
 		logger.Bool("forward_headers", proxyCfg.ForwardHeaders),
 		logger.String("bad_gateway_page", proxyCfg.BadGatewayPage),
 		logger.Bool("h2c_enabled", proxyCfg.H2cEnabled),
-	}
-	if unixSocketPath != "" {
-		loggerFields = append(loggerFields, logger.String("unix_socket", unixSocketPath))
-	}
-
-	logger.Info("reverse proxy enabled", loggerFields...)
+		logger.Int("max_retries", retry.MaxRetries),
+		logger.Bool("hold_enabled", hold.Enabled),
+	)
 
 	var handler http.Handler = reverseProxy
 
+	// Cached responses are still served while the upstream boots; everything
+	// else waits for it to pass its readiness check.
+	if len(upstreams) > 0 {
+		var maxWait time.Duration
+		if hold.Enabled {
+			maxWait = hold.MaxWait
+		}
+		handler = proxy.NewReadinessGate(upstreams, maxWait, handler)
+	}
+
 	if proxyCfg.Cache.Enabled {
 		capacity := proxyCfg.Cache.CapacityBytes
 		maxItemSize := proxyCfg.Cache.MaxItemSizeBytes
@@ -91,13 +138,37 @@ func registerReverseProxy(r *gin.Engine) {
 		}
 
 		if capacity > 0 && maxItemSize > 0 && maxBodySize > 0 {
-			cache := proxcache.NewMemoryCache(capacity, maxItemSize)
-			handler = proxcache.NewCacheHandler(cache, maxBodySize, handler)
+			cache, err := newProxyCache(proxyCfg.Cache)
+			if err != nil {
+				logger.Fatal("failed to create reverse proxy cache", logger.String("backend", proxyCfg.Cache.Backend), logger.Err(err))
+				return nil
+			}
+			if closer, ok := cache.(io.Closer); ok {
+				o.onClose(closer.Close)
+			}
+			rules := proxyCacheRules(proxyCfg.Cache.Rules)
+			if err := rules.Validate(); err != nil {
+				logger.Fatal("invalid reverse proxy cache rules", logger.Err(err))
+				return nil
+			}
+			o.cache = proxcache.NewCacheHandler(cache, maxBodySize, handler,
+				proxcache.WithStaleIfErrorTimeout(time.Duration(proxyCfg.Cache.StaleIfErrorTimeout)*time.Second),
+				proxcache.WithCollapseTimeout(time.Duration(proxyCfg.Cache.CollapseTimeout)*time.Second),
+				proxcache.WithRevalidationWindow(time.Duration(proxyCfg.Cache.RevalidationWindow)*time.Second),
+				proxcache.WithRangeFill(proxyCfg.Cache.RangeFill),
+				proxcache.WithRules(rules))
+			handler = o.cache
 			logger.Info(
 				"reverse proxy cache enabled",
+				logger.String("backend", proxyCfg.Cache.Backend),
 				logger.Int("capacity_bytes", capacity),
 				logger.Int("max_item_size_bytes", maxItemSize),
 				logger.Int("max_body_size_bytes", maxBodySize),
+				logger.Int("stale_if_error_timeout", proxyCfg.Cache.StaleIfErrorTimeout),
+				logger.Int("collapse_timeout", proxyCfg.Cache.CollapseTimeout),
+				logger.Int("revalidation_window", proxyCfg.Cache.RevalidationWindow),
+				logger.Bool("range_fill", proxyCfg.Cache.RangeFill),
+				logger.Int("path_rules", len(rules.Paths)),
 			)
 		} else {
 			logger.Warn(
@@ -121,4 +192,140 @@ func registerReverseProxy(r *gin.Engine) {
 
 	r.NoRoute(ginHandler)
 	r.NoMethod(ginHandler)
+
+	return pool
+}
+
+// newProxyCache creates the storage backend selected by proxy.cache.backend.
+func newProxyCache(cfg config.Cache) (proxcache.Cache, error) {
+	switch cfg.Backend {
+	case "", "memory":
+		return proxcache.NewMemoryCache(cfg.CapacityBytes, cfg.MaxItemSizeBytes), nil
+	case "disk":
+		return proxcache.NewDiskCache(cfg.Directory, cfg.CapacityBytes, cfg.MaxItemSizeBytes)
+	case "redis":
+		return proxcache.NewRedisCache(database.GetRedisCli(), proxcache.RedisCacheOptions{
+			KeyPrefix:   cfg.Redis.KeyPrefix,
+			MaxItemSize: cfg.MaxItemSizeBytes,
+			L1Capacity:  cfg.Redis.L1CapacityBytes,
+			L1TTL:       time.Duration(cfg.Redis.L1TTL) * time.Second,
+		}), nil
+	}
+	return nil, fmt.Errorf("unsupported cache backend %q", cfg.Backend)
+}
+
+// proxyCacheRules converts the configured cache rules; TTLs are in seconds.
+func proxyCacheRules(cfg config.CacheRules) proxcache.Rules {
+	rules := proxcache.Rules{
+		BypassCookies: cfg.BypassCookies,
+		BypassHeaders: cfg.BypassHeaders,
+		IgnoreQuery:   cfg.IgnoreQuery,
+		KeyCookies:    cfg.KeyCookies,
+		KeyHeaders:    cfg.KeyHeaders,
+	}
+	for _, p := range cfg.Paths {
+		rules.Paths = append(rules.Paths, proxcache.PathRule{
+			Pattern: p.Pattern,
+			TTL:     time.Duration(p.TTL) * time.Second,
+			NoCache: p.NoCache,
+		})
+	}
+	return rules
+}
+
+// followUpstreamSwitches replaces the target of an upstream in the pool whenever
+// a phased restart moves it to a new address. targets[i] belongs to upstreams[i].
+func followUpstreamSwitches(pool *proxy.Pool, upstreams upstream.Group, targets []proxy.Target, rawBaseURL string) {
+	var baseURL *url.URL
+	if rawBaseURL != "" {
+		baseURL, _ = url.Parse(rawBaseURL)
+	}
+
+	var mu sync.Mutex
+	current := append([]proxy.Target(nil), targets...)
+	for i, s := range upstreams {
+		s.OnSwitch(func(network, address string) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			current[i] = upstreamTarget(baseURL, network, address)
+			if err := pool.SetTargets(append([]proxy.Target(nil), current...)); err != nil {
+				logger.Error("failed to switch reverse proxy target", logger.String("upstream", s.Name()), logger.String("address", address), logger.Err(err))
+				return
+			}
+			logger.Info("reverse proxy switched upstream target", logger.String("upstream", s.Name()), logger.String("address", address))
+		})
+	}
+}
+
+// proxyTargets resolves the upstream targets from proxy.targets, falling back to
+// the supervised upstreams that serve traffic and finally to proxy.targetURL.
+func proxyTargets(cfg *config.Config, upstreams upstream.Group) ([]proxy.Target, error) {
+	proxyCfg := cfg.Proxy
+	if len(proxyCfg.Targets) > 0 {
+		targets := make([]proxy.Target, 0, len(proxyCfg.Targets))
+		for _, t := range proxyCfg.Targets {
+			target, err := proxy.ParseTarget(t.URL, t.Weight)
+			if err != nil {
+				return nil, fmt.Errorf("parse proxy target %q: %w", t.URL, err)
+			}
+			targets = append(targets, target)
+		}
+		return targets, nil
+	}
+
+	var baseURL *url.URL
+	if proxyCfg.TargetURL != "" {
+		u, err := url.Parse(proxyCfg.TargetURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy target url %q: %w", proxyCfg.TargetURL, err)
+		}
+		baseURL = u
+	}
+
+	if len(upstreams) > 0 {
+		targets := make([]proxy.Target, 0, len(upstreams))
+		for _, s := range upstreams {
+			network, address := s.Address()
+			if network == "" {
+				return nil, fmt.Errorf("upstream %q serves traffic but has no targetPort or targetBindSocket", s.Name())
+			}
+			targets = append(targets, upstreamTarget(baseURL, network, address))
+		}
+		return targets, nil
+	}
+
+	if baseURL == nil {
+		if cfg.Upstream.TargetBindSocket == "" {
+			return nil, errors.New("proxy target url not configured")
+		}
+		// When a UNIX socket is configured, we still need a valid HTTP URL
+		// for request rewriting; the transport will dial the socket.
+		baseURL = &url.URL{Scheme: "http", Host: "localhost"}
+		return []proxy.Target{{URL: baseURL, UnixSocketPath: cfg.Upstream.TargetBindSocket}}, nil
+	}
+
+	return []proxy.Target{{URL: baseURL}}, nil
+}
+
+// upstreamTarget points the proxy at the listen address of a supervised upstream.
+// UNIX sockets are preferred by the upstream whenever configured, which avoids
+// TCP self-loops when the HTTP server and upstream share a port. For TCP only
+// the port of base is replaced so the Host sent upstream stays as configured.
+func upstreamTarget(base *url.URL, network, address string) proxy.Target {
+	if network == "unix" {
+		if base == nil {
+			base = &url.URL{Scheme: "http", Host: "localhost"}
+		}
+		return proxy.Target{URL: base, UnixSocketPath: address}
+	}
+
+	u := url.URL{Scheme: "http", Host: address}
+	if base != nil {
+		u = *base
+		if _, port, err := net.SplitHostPort(address); err == nil {
+			u.Host = net.JoinHostPort(base.Hostname(), port)
+		}
+	}
+	return proxy.Target{URL: &u}
 }
@@ -28,7 +28,10 @@ var (
 )
 
 // NewRouter create a new router
-func NewRouter() *gin.Engine {
+func NewRouter(opts ...Option) *gin.Engine {
+	o := defaultOptions()
+	o.apply(opts...)
+
 	r := gin.New()
 
 	r.Use(gin.Recovery())
@@ -86,7 +89,6 @@ func NewRouter() *gin.Engine {
 		prof.Register(r, prof.WithIOWaitTime())
 	}
 
-	r.GET("/health", handlerfunc.CheckHealth)
 	r.GET("/ping", handlerfunc.Ping)
 	r.GET("/codes", handlerfunc.ListCodes)
 
@@ -104,7 +106,9 @@ func NewRouter() *gin.Engine {
 	// example:
 	//    registerRouters(r, "/api/v2", apiV2RouteFns, middleware.Auth())
 
-	registerReverseProxy(r)
+	pool := registerReverseProxy(r, o)
+	registerAdmin(r, o)
+	r.GET("/health", checkHealth(pool, o.proxyUpstreams()))
 
 	return r
 }
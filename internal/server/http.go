@@ -31,6 +31,9 @@ type httpServer struct {
 	httpServer  *http.Server
 	httpsServer *http.Server
 	tlsEnabled  bool
+
+	// closes stop the background work of the routes
+	closes []func() error
 }
 
 var (
@@ -90,6 +93,12 @@ func (s *httpServer) Stop() error {
 		}
 	}
 
+	for _, closeFn := range s.closes {
+		if err := closeFn(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
 	return firstErr
 }
 
@@ -132,9 +141,10 @@ func NewHTTPServer(cfg config.HTTP, opts ...HTTPOption) app.IServer {
 		gin.SetMode(gin.DebugMode)
 	}
 
+	var closes []func() error
 	appHandler := o.handler
 	if appHandler == nil {
-		appHandler = routers.NewRouter()
+		appHandler = routers.NewRouter(routers.WithUpstreams(o.upstreams...), routers.WithCloses(&closes))
 	}
 
 	appHandler = httpmiddleware.Wrap(appHandler, httpmiddleware.Options{
@@ -190,6 +200,7 @@ func NewHTTPServer(cfg config.HTTP, opts ...HTTPOption) app.IServer {
 		httpServer:  httpSrv,
 		httpsServer: httpsSrv,
 		tlsEnabled:  tlsEnabled,
+		closes:      closes,
 	}
 }
 
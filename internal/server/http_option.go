@@ -1,13 +1,18 @@
 package server
 
-import "net/http"
+import (
+	"net/http"
+
+	"thrust_oauth2id/internal/upstream"
+)
 
 // HTTPOption setting up http
 type HTTPOption func(*httpOptions)
 
 type httpOptions struct {
-	isProd  bool
-	handler http.Handler
+	isProd    bool
+	handler   http.Handler
+	upstreams []*upstream.Server
 }
 
 func defaultHTTPOptions() *httpOptions {
@@ -35,3 +40,10 @@ func WithHTTPHandler(handler http.Handler) HTTPOption {
 		o.handler = handler
 	}
 }
+
+// WithHTTPUpstreams connects the reverse proxy to the supervised upstream processes
+func WithHTTPUpstreams(servers ...*upstream.Server) HTTPOption {
+	return func(o *httpOptions) {
+		o.upstreams = servers
+	}
+}
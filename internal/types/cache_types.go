@@ -0,0 +1,38 @@
+package types
+
+// PurgeCacheRequest request params, set criteria must all match
+type PurgeCacheRequest struct {
+	URL    string   `json:"url"`    // absolute url, or path with query matching any host
+	Prefix string   `json:"prefix"` // request path prefix such as "/posts/"
+	Host   string   `json:"host"`   // request host such as "example.com"
+	Tags   []string `json:"tags"`   // Surrogate-Key or Cache-Tag values such as "post-42"
+	All    bool     `json:"all"`    // purge everything, other criteria are ignored
+}
+
+// PurgeCacheReply only for api docs
+type PurgeCacheReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Purged int `json:"purged"` // number of cached responses removed
+	} `json:"data"` // return data
+}
+
+// CacheEntry a cached response
+type CacheEntry struct {
+	Key           string              `json:"key"`           // cache key in hex
+	URL           string              `json:"url"`           // request host, path and normalized query
+	Size          int                 `json:"size"`          // stored size in bytes
+	VariantHeader map[string][]string `json:"variantHeader"` // request headers named by Vary that select this variant
+	TTL           int64               `json:"ttl"`           // seconds the response stays fresh, 0 once stale
+}
+
+// ListCacheKeysReply only for api docs
+type ListCacheKeysReply struct {
+	Code int    `json:"code"` // return code
+	Msg  string `json:"msg"`  // return information description
+	Data struct {
+		Entries []CacheEntry `json:"entries"` // cached responses ordered by url
+		Total   int          `json:"total"`   // number of matching cached responses
+	} `json:"data"` // return data
+}
@@ -0,0 +1,6 @@
+package types
+
+// SignalUpstreamRequest request params
+type SignalUpstreamRequest struct {
+	Signal string `json:"signal" binding:"required"` // signal name such as "USR1", "SIGTTIN" or "WINCH"
+}
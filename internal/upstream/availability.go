@@ -0,0 +1,292 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	availabilityPollInterval = 100 * time.Millisecond
+	defaultReadinessTimeout  = 60 * time.Second
+)
+
+var (
+	errNotRunning        = errors.New("upstream process is not running")
+	errExitedBeforeReady = errors.New("upstream process exited before becoming ready")
+	errNotReady          = errors.New("upstream process did not become ready in time")
+)
+
+// Lifecycle states of the supervised process.
+const (
+	stateStopped = iota
+	stateStarting
+	stateRunning
+)
+
+var stateNames = map[int]string{
+	stateStopped:  "stopped",
+	stateStarting: "starting",
+	stateRunning:  "running",
+}
+
+// Restarting reports whether the upstream process has been launched but does not
+// accept connections yet, i.e. callers can expect it to become available soon.
+func (s *Server) Restarting() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.state == stateStarting
+}
+
+// WaitReady blocks until the upstream accepts connections, the process exits or
+// ctx is done.
+func (s *Server) WaitReady(ctx context.Context) error {
+	for {
+		s.mu.Lock()
+		state, proc := s.state, s.current
+		s.mu.Unlock()
+
+		switch state {
+		case stateRunning:
+			return nil
+		case stateStopped:
+			return errNotRunning
+		}
+
+		if proc == nil {
+			// pre-start hooks are still running
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(availabilityPollInterval):
+				continue
+			}
+		}
+
+		select {
+		case <-proc.ready:
+			return nil
+		case <-proc.done:
+			return errNotRunning
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// listenAddress returns where the given generation slot is expected to accept
+// connections. Slot 0 is the configured address; slot 1 is the alternate address
+// used while a reload runs both generations side by side.
+func (s *Server) listenAddress(slot int) (network, address string) {
+	if s.cfg.TargetBindSocket != "" {
+		path := normalizeSocketPath(s.cfg.TargetBindSocket)
+		if slot == 0 {
+			return "unix", path
+		}
+		if s.cfg.Reload.AlternateSocket != "" {
+			return "unix", normalizeSocketPath(s.cfg.Reload.AlternateSocket)
+		}
+		return "unix", strings.TrimSuffix(path, ".sock") + ".next.sock"
+	}
+	if s.cfg.TargetPort > 0 {
+		port := s.cfg.TargetPort
+		if slot != 0 {
+			port = s.cfg.Reload.AlternatePort
+			if port == 0 {
+				port = s.cfg.TargetPort + 1
+			}
+		}
+		return "tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	}
+	return "", ""
+}
+
+// watchAvailability marks the process running once it passes the readiness
+// check. It gives up when the process exits, and stops a process that is not
+// ready within the readiness timeout so the restart policy can take over.
+func (s *Server) watchAvailability(proc *process) {
+	if proc.network == "" {
+		s.markRunning(proc)
+		return
+	}
+
+	timeout := s.readinessTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	started := time.Now()
+	if err := s.waitHealthy(ctx, proc); err != nil {
+		if errors.Is(err, errExitedBeforeReady) {
+			return
+		}
+
+		logger.Error("upstream process not ready within timeout; stopping it",
+			logger.String("name", s.Name()),
+			logger.Int("pid", proc.pid()),
+			logger.String("address", proc.address),
+			logger.Duration("timeout", timeout),
+			logger.Err(err))
+		proc.unready.Store(true)
+		if stopErr := s.stopProcess(proc); stopErr != nil {
+			logger.Warn("failed to stop unready upstream process", logger.String("name", s.Name()), logger.Int("pid", proc.pid()), logger.Err(stopErr))
+		}
+		return
+	}
+
+	s.markRunning(proc)
+	logger.Info("upstream process ready",
+		logger.String("name", s.Name()),
+		logger.Int("pid", proc.pid()),
+		logger.String("address", proc.address),
+		logger.Duration("startup", time.Since(started)))
+}
+
+func (s *Server) readinessTimeout() time.Duration {
+	if s.cfg.Readiness.Timeout > 0 {
+		return time.Duration(s.cfg.Readiness.Timeout) * time.Second
+	}
+	return defaultReadinessTimeout
+}
+
+func (s *Server) markRunning(proc *process) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	proc.markReady()
+	if s.current != proc {
+		return
+	}
+	s.state = stateRunning
+}
+
+// waitHealthy blocks until a freshly spawned generation accepts connections and,
+// when a readiness path is configured, answers it with a 2xx or 3xx status.
+func (s *Server) waitHealthy(ctx context.Context, proc *process) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-proc.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	if proc.network != "" {
+		if err := waitAccepting(ctx, proc.network, proc.address); err != nil {
+			return s.readinessError(proc, err)
+		}
+	}
+
+	if s.cfg.Readiness.Path == "" || proc.network == "" {
+		return nil
+	}
+
+	client := readinessClient(proc.network, proc.address)
+	defer client.CloseIdleConnections()
+
+	ticker := time.NewTicker(availabilityPollInterval)
+	defer ticker.Stop()
+
+	for {
+		err := probeReadiness(ctx, client, s.cfg.Readiness.Path)
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return s.readinessError(proc, err)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) readinessError(proc *process, err error) error {
+	select {
+	case <-proc.done:
+		return errExitedBeforeReady
+	default:
+		return err
+	}
+}
+
+// readinessClient dials the generation's own address regardless of the URL host.
+func readinessClient(network, address string) *http.Client {
+	dialer := &net.Dialer{Timeout: time.Second}
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, address)
+			},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func probeReadiness(ctx context.Context, client *http.Client, path string) error {
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost"+path, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close() //nolint
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 399 {
+		return fmt.Errorf("readiness check returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// waitAccepting polls the address until a connection succeeds or ctx is done.
+func waitAccepting(ctx context.Context, network, address string) error {
+	ticker := time.NewTicker(availabilityPollInterval)
+	defer ticker.Stop()
+
+	dialer := &net.Dialer{Timeout: time.Second}
+	for {
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err == nil {
+			_ = conn.Close()
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// normalizeSocketPath strips the unix:// scheme accepted in configuration.
+func normalizeSocketPath(in string) string {
+	s := strings.TrimSpace(in)
+	if strings.HasPrefix(s, "unix://") {
+		s = strings.TrimPrefix(s, "unix://")
+		if !strings.HasPrefix(s, "/") {
+			s = "/" + s
+		}
+	}
+	return s
+}
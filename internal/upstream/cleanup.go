@@ -0,0 +1,180 @@
+package upstream
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const groupPollInterval = 50 * time.Millisecond
+
+// newProcessGroupAttr starts the command as the leader of its own process
+// group, so wrappers like "rbenv exec bundle exec puma" can be stopped together
+// with the processes they spawn.
+func newProcessGroupAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalGroup delivers sig to every process in the group led by pgid.
+func signalGroup(pgid int, sig syscall.Signal) error {
+	err := syscall.Kill(-pgid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return os.ErrProcessDone
+	}
+	return err
+}
+
+// groupAlive reports whether any process of the group still exists.
+func groupAlive(pgid int) bool {
+	err := syscall.Kill(-pgid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// reapGroup makes sure no member of a process group outlives its leader: the
+// leftovers get the stop signal and are killed after the stop timeout.
+func (s *Server) reapGroup(pgid int) {
+	if !groupAlive(pgid) {
+		return
+	}
+
+	logger.Warn("upstream processes left behind by exited process; stopping them",
+		logger.String("name", s.Name()),
+		logger.Int("pgid", pgid))
+	_ = signalGroup(pgid, s.stopSignal())
+	if waitGroupExit(pgid, s.stopTimeout()) {
+		return
+	}
+
+	logger.Warn("leftover upstream processes did not exit within timeout; killing",
+		logger.String("name", s.Name()),
+		logger.Int("pgid", pgid),
+		logger.Duration("timeout", s.stopTimeout()))
+	_ = signalGroup(pgid, syscall.SIGKILL)
+	if !waitGroupExit(pgid, time.Second) {
+		logger.Error("leftover upstream processes survived SIGKILL", logger.String("name", s.Name()), logger.Int("pgid", pgid))
+	}
+}
+
+func waitGroupExit(pgid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for groupAlive(pgid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(groupPollInterval)
+	}
+	return true
+}
+
+// cleanupStale removes what a previous, uncleanly stopped run left behind: a
+// process still running under the pidfile and the pidfile itself, and a socket
+// file nobody listens on.
+func (s *Server) cleanupStale(network, address string) {
+	if s.cfg.PidFile != "" {
+		s.cleanupPidFile(s.cfg.PidFile)
+	}
+	// with socket activation the socket is ours and is set up by listenerFile
+	if network == "unix" && !s.cfg.SocketActivation {
+		if err := removeStaleSocket(address); errors.Is(err, syscall.EADDRINUSE) {
+			logger.Warn("upstream socket is still in use by another process",
+				logger.String("name", s.Name()),
+				logger.String("socket", address))
+		}
+	}
+}
+
+func (s *Server) cleanupPidFile(path string) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	switch {
+	case err != nil || pid <= 1 || pid == os.Getpid() || !processAlive(pid):
+	case !s.ownsProcess(pid):
+		// the pid was reused by a process that is not the upstream
+		logger.Warn("pid file of a previous run names a process that is not the upstream; leaving it running",
+			logger.String("name", s.Name()),
+			logger.Int("pid", pid),
+			logger.String("pid_file", path))
+	default:
+		logger.Warn("upstream process from a previous run is still running; stopping it",
+			logger.String("name", s.Name()),
+			logger.Int("pid", pid),
+			logger.String("pid_file", path))
+		s.stopOrphan(pid)
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		logger.Warn("failed to remove stale pid file", logger.String("name", s.Name()), logger.String("pid_file", path), logger.Err(err))
+	}
+}
+
+// ownsProcess reports whether pid runs in the working directory of the
+// upstream, which is what tells a leftover upstream apart from an unrelated
+// process that got its pid. Without /proc nothing is recognised.
+func (s *Server) ownsProcess(pid int) bool {
+	cwd, err := os.Readlink("/proc/" + strconv.Itoa(pid) + "/cwd")
+	if err != nil {
+		return false
+	}
+	dir := s.cfg.WorkingDirectory
+	if dir == "" {
+		if dir, err = os.Getwd(); err != nil {
+			return false
+		}
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		dir = resolved
+	}
+	dir, err = filepath.Abs(dir)
+	return err == nil && cwd == dir
+}
+
+// stopOrphan stops a process we did not start, and its group when it leads one
+// other than ours.
+func (s *Server) stopOrphan(pid int) {
+	signal := func(sig syscall.Signal) {
+		if pgid, err := syscall.Getpgid(pid); err == nil && pgid == pid && pgid != syscall.Getpgrp() {
+			_ = signalGroup(pid, sig)
+			return
+		}
+		_ = syscall.Kill(pid, sig)
+	}
+
+	signal(s.stopSignal())
+	deadline := time.Now().Add(s.stopTimeout())
+	for processAlive(pid) {
+		if time.Now().After(deadline) {
+			signal(syscall.SIGKILL)
+			return
+		}
+		time.Sleep(groupPollInterval)
+	}
+}
+
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
+
+// removeStaleSocket deletes a socket file nobody is listening on.
+func removeStaleSocket(path string) error {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return err
+	}
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		_ = conn.Close()
+		return syscall.EADDRINUSE
+	}
+	return os.Remove(path)
+}
@@ -0,0 +1,145 @@
+package upstream
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestStopSignalsWholeProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+	s := NewServer(config.Upstream{
+		Enabled:     true,
+		Command:     os.Args[0],
+		Args:        []string{"-test.run=TestHelperProcess"},
+		Env:         config.Env{"UPSTREAM_HELPER_PROCESS": "1", "UPSTREAM_HELPER_CHILD_PIDFILE": pidFile},
+		TargetPort:  freePort(t),
+		StopTimeout: 2,
+	})
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for s.Restarting() || s.WaitReady(ctx) != nil {
+		if ctx.Err() != nil {
+			t.Fatal("upstream did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("helper did not record its child: %v", err)
+	}
+	child, _ := strconv.Atoi(strings.TrimSpace(string(data)))
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// the orphaned child is reparented to init, which reaps it shortly after it dies
+	deadline := time.Now().Add(5 * time.Second)
+	for processAlive(child) {
+		if time.Now().After(deadline) {
+			_ = syscall.Kill(child, syscall.SIGKILL)
+			t.Fatalf("child %d of the upstream survived Stop()", child)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCleanupPidFileStopsLeftoverProcess(t *testing.T) {
+	t.Parallel()
+
+	leftover := exec.Command("sleep", "60")
+	if err := leftover.Start(); err != nil {
+		t.Fatal(err)
+	}
+	exited := make(chan struct{})
+	go func() {
+		_ = leftover.Wait()
+		close(exited)
+	}()
+
+	pidFile := filepath.Join(t.TempDir(), "server.pid")
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(leftover.Process.Pid)+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s := NewServer(config.Upstream{PidFile: pidFile, StopTimeout: 1})
+	s.cleanupStale("", "")
+
+	select {
+	case <-exited:
+	case <-time.After(5 * time.Second):
+		_ = leftover.Process.Kill()
+		t.Fatal("leftover process was not stopped")
+	}
+	if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+		t.Fatalf("pid file still present: %v", err)
+	}
+}
+
+func TestCleanupPidFileLeavesOtherProcesses(t *testing.T) {
+	t.Parallel()
+
+	unrelated := exec.Command("sleep", "60")
+	unrelated.Dir = t.TempDir()
+	if err := unrelated.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = unrelated.Process.Kill()
+		_ = unrelated.Wait()
+	})
+
+	dir := t.TempDir()
+	for _, pid := range []int{1, os.Getpid(), unrelated.Process.Pid} {
+		pidFile := filepath.Join(dir, "server.pid")
+		if err := os.WriteFile(pidFile, []byte(strconv.Itoa(pid)+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		NewServer(config.Upstream{PidFile: pidFile, WorkingDirectory: dir, StopTimeout: 1}).cleanupStale("", "")
+
+		if _, err := os.Stat(pidFile); !os.IsNotExist(err) {
+			t.Fatalf("pid file naming %d still present: %v", pid, err)
+		}
+	}
+	if !processAlive(unrelated.Process.Pid) {
+		t.Fatal("a process outside the upstream working directory was stopped")
+	}
+}
+
+func TestCleanupRemovesStaleSocket(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "puma.sock")
+	fd, err := syscall.Socket(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bound but never listening: what a crashed server leaves behind
+	if err := syscall.Bind(fd, &syscall.SockaddrUnix{Name: path}); err != nil {
+		t.Fatal(err)
+	}
+	_ = syscall.Close(fd)
+
+	NewServer(config.Upstream{}).cleanupStale("unix", path)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("stale socket still present: %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// parseDotenv reads KEY=VALUE pairs in the format used by dotenv and foreman:
+//
+//	# comment
+//	export RAILS_ENV=production
+//	DATABASE_URL="postgres://${DB_HOST:-localhost}/app" # trailing comment
+//	GREETING='single quotes are taken literally: ${NOT_EXPANDED}'
+//	PRIVATE_KEY="-----BEGIN KEY-----
+//	...
+//	-----END KEY-----"
+//
+// Double quoted values understand \n, \t, \", \\ and \$ escapes and may span lines.
+// Unquoted and double quoted values expand ${VAR}, $VAR and ${VAR:-default}
+// using the pairs parsed so far, then lookup.
+func parseDotenv(data string, lookup func(string) (string, bool)) ([][2]string, error) {
+	var pairs [][2]string
+	vars := map[string]string{}
+	expand := func(name string) (string, bool) {
+		if value, ok := vars[name]; ok {
+			return value, true
+		}
+		return lookup(name)
+	}
+
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	for pos := 0; pos < len(data); {
+		lineNo := strings.Count(data[:pos], "\n") + 1
+		line := data[pos:]
+		if end := strings.IndexByte(line, '\n'); end >= 0 {
+			line = line[:end]
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			pos += len(line) + 1
+			continue
+		}
+
+		key, raw, ok := strings.Cut(strings.TrimPrefix(trimmed, "export "), "=")
+		key = strings.TrimSpace(key)
+		if !ok || !validEnvKey(key) {
+			return nil, fmt.Errorf("line %d: expected KEY=VALUE", lineNo)
+		}
+		raw = strings.TrimLeft(raw, " \t")
+		// offset of the value within data, so quoted values can span lines
+		valuePos := pos + strings.Index(line, "=") + 1
+		valuePos += len(data[valuePos:]) - len(strings.TrimLeft(data[valuePos:], " \t"))
+
+		var value string
+		switch {
+		case strings.HasPrefix(raw, "'"):
+			end := strings.IndexByte(data[valuePos+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unterminated single quoted value for %s", lineNo, key)
+			}
+			value = data[valuePos+1 : valuePos+1+end]
+			pos = valuePos + end + 2
+
+		case strings.HasPrefix(raw, `"`):
+			quoted, literal, consumed, err := unquoteDouble(data[valuePos+1:])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w for %s", lineNo, err, key)
+			}
+			value = expandEnv(quoted, literal, expand)
+			pos = valuePos + 1 + consumed
+
+		default:
+			if i := strings.Index(raw, " #"); i >= 0 {
+				raw = raw[:i]
+			}
+			unquoted, literal := unescapeDollars(strings.TrimSpace(raw))
+			value = expandEnv(unquoted, literal, expand)
+			pos += len(line)
+		}
+
+		// only a comment may follow a quoted value on its closing line
+		tail := data[pos:]
+		if end := strings.IndexByte(tail, '\n'); end >= 0 {
+			tail = tail[:end]
+		}
+		if t := strings.TrimSpace(tail); t != "" && !strings.HasPrefix(t, "#") {
+			return nil, fmt.Errorf("line %d: unexpected %q after value of %s", lineNo, t, key)
+		}
+		pos += len(tail) + 1
+
+		vars[key] = value
+		pairs = append(pairs, [2]string{key, value})
+	}
+
+	return pairs, nil
+}
+
+// unquoteDouble decodes a double quoted value whose opening quote has already
+// been consumed, returning the value, the offsets of the escaped and so literal
+// dollar signs in it, and how many bytes of s it used.
+func unquoteDouble(s string) (string, []int, int, error) {
+	var b strings.Builder
+	var literal []int
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), literal, i + 1, nil
+		case '\\':
+			if i+1 == len(s) {
+				break
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '$':
+				literal = append(literal, b.Len())
+				b.WriteByte('$')
+			case '"', '\\':
+				b.WriteByte(s[i])
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", nil, 0, errors.New("unterminated double quoted value")
+}
+
+// unescapeDollars turns \$ in an unquoted value into a dollar sign, returning
+// the value and the offsets of those literal dollar signs.
+func unescapeDollars(s string) (string, []int) {
+	if !strings.Contains(s, `\$`) {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var literal []int
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) && s[i+1] == '$' {
+			literal = append(literal, b.Len())
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String(), literal
+}
+
+// expandEnv replaces ${VAR}, ${VAR:-default} and $VAR; unknown variables expand
+// to the empty string. The dollar signs at the ascending offsets in literal
+// were escaped and are kept as they are.
+func expandEnv(s string, literal []int, lookup func(string) (string, bool)) string {
+	if !strings.Contains(s, "$") {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		for len(literal) > 0 && literal[0] < i {
+			literal = literal[1:]
+		}
+		if c != '$' || i+1 == len(s) || (len(literal) > 0 && literal[0] == i) {
+			b.WriteByte(c)
+			continue
+		}
+
+		if s[i+1] == '{' {
+			end := strings.IndexByte(s[i+2:], '}')
+			if end < 0 {
+				b.WriteString(s[i:])
+				break
+			}
+			name, fallback, hasFallback := strings.Cut(s[i+2:i+2+end], ":-")
+			value, ok := lookup(name)
+			if (!ok || value == "") && hasFallback {
+				value = fallback
+			}
+			b.WriteString(value)
+			i += end + 2
+			continue
+		}
+
+		j := i + 1
+		for j < len(s) && isEnvKeyByte(s[j], j == i+1) {
+			j++
+		}
+		if j == i+1 {
+			b.WriteByte(c)
+			continue
+		}
+		value, _ := lookup(s[i+1 : j])
+		b.WriteString(value)
+		i = j - 1
+	}
+	return b.String()
+}
+
+func validEnvKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for i := 0; i < len(key); i++ {
+		if !isEnvKeyByte(key[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+func isEnvKeyByte(c byte, first bool) bool {
+	switch {
+	case c == '_', 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z':
+		return true
+	case '0' <= c && c <= '9':
+		return !first
+	}
+	return false
+}
+
+// readDotenv loads a dotenv file.
+func readDotenv(path string, lookup func(string) (string, bool)) ([][2]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := parseDotenv(string(data), lookup)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return pairs, nil
+}
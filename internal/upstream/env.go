@@ -0,0 +1,199 @@
+package upstream
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"thrust_oauth2id/internal/config"
+)
+
+const (
+	secretFileSuffix = "_FILE"
+
+	// sources of a variable, as reported by DescribeEnv
+	sourceInherited = "inherited"
+	sourceUser      = "user"
+	sourceListen    = "listen address"
+	sourceInline    = "upstream.env"
+	// set for the upstream process only, not for hooks
+	sourceActivation = "socket activation"
+)
+
+// envVar is one variable of the upstream environment and where it came from.
+type envVar struct {
+	value  string
+	source string
+}
+
+// buildEnv returns the environment the upstream command or a hook starts with.
+func (s *Server) buildEnv(network, address string) ([]string, error) {
+	vars, err := resolveEnv(s.cfg, s.attrs, network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	env := make([]string, 0, len(vars))
+	for key, v := range vars {
+		env = append(env, key+"="+v.value)
+	}
+	return env, nil
+}
+
+// DescribeEnv lists every variable the upstream would start with and where its
+// value comes from. It only looks at names: secret files are not read, the user
+// is not looked up and its values are never revealed.
+func DescribeEnv(cfg config.Upstream) (map[string]string, error) {
+	var attrs *processAttrs
+	if cfg.User != "" {
+		attrs = &processAttrs{username: cfg.User}
+	}
+	network, address := NewServer(cfg).listenAddress(0)
+
+	vars, err := mergeEnv(cfg, attrs, network, address, func(string) (string, error) { return "", nil })
+	if err != nil {
+		return nil, err
+	}
+	if cfg.SocketActivation && network != "" {
+		for _, key := range []string{"LISTEN_FDS", "LISTEN_FDNAMES", "LISTEN_PID"} {
+			vars[key] = envVar{source: sourceActivation}
+		}
+	}
+
+	described := make(map[string]string, len(vars))
+	for key, v := range vars {
+		described[key] = "****** (" + v.source + ")"
+	}
+	return described, nil
+}
+
+// resolveEnv merges, in increasing precedence: the inherited environment
+// filtered by inheritEnv, the user the upstream runs as, the listen address,
+// envFiles in order and the inline env map. Afterwards NAME_FILE variables are
+// resolved into NAME holding the contents of that file, the way secrets mounted
+// by Kubernetes or Docker are usually passed.
+func resolveEnv(cfg config.Upstream, attrs *processAttrs, network, address string) (map[string]envVar, error) {
+	return mergeEnv(cfg, attrs, network, address, readSecretFile)
+}
+
+// mergeEnv is resolveEnv with readSecret returning the contents of a secret file.
+func mergeEnv(cfg config.Upstream, attrs *processAttrs, network, address string,
+	readSecret func(path string) (string, error)) (map[string]envVar, error) {
+	vars := map[string]envVar{}
+
+	for _, kv := range os.Environ() {
+		key, value, ok := strings.Cut(kv, "=")
+		if ok && inheritEnv(cfg.InheritEnv, key) {
+			vars[key] = envVar{value: value, source: sourceInherited}
+		}
+	}
+
+	for key, value := range attrs.env() {
+		vars[key] = envVar{value: value, source: sourceUser}
+	}
+
+	// Only export PORT when not using a UNIX socket binding to avoid conflicts.
+	switch network {
+	case "tcp":
+		if _, port, err := net.SplitHostPort(address); err == nil {
+			vars["PORT"] = envVar{value: port, source: sourceListen}
+		}
+	case "unix":
+		vars[socketEnvKey] = envVar{value: address, source: sourceListen}
+	}
+
+	lookup := func(name string) (string, bool) {
+		if v, ok := vars[name]; ok {
+			return v.value, true
+		}
+		return os.LookupEnv(name)
+	}
+	for _, file := range cfg.EnvFiles {
+		if !filepath.IsAbs(file) && cfg.WorkingDirectory != "" {
+			file = filepath.Join(cfg.WorkingDirectory, file)
+		}
+		pairs, err := readDotenv(file, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("load env file: %w", err)
+		}
+		for _, kv := range pairs {
+			// the listen address is assigned by the supervisor; a PORT left in a
+			// .env file for local development must not override it
+			if v, ok := vars[kv[0]]; ok && v.source == sourceListen {
+				continue
+			}
+			vars[kv[0]] = envVar{value: kv[1], source: file}
+		}
+	}
+
+	for key, value := range cfg.Env {
+		vars[key] = envVar{value: value, source: sourceInline}
+	}
+
+	if err := resolveSecretFiles(vars, cfg.InheritEnv.Allow, readSecret); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
+
+// inheritEnv applies the allow and deny lists, which hold shell patterns such
+// as "RAILS_*", to a variable of this process' environment.
+func inheritEnv(rules config.InheritEnv, key string) bool {
+	if len(rules.Allow) > 0 && !matchEnvPattern(rules.Allow, key) {
+		return false
+	}
+	return !matchEnvPattern(rules.Deny, key)
+}
+
+func matchEnvPattern(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveSecretFiles only considers inherited variables that an allow pattern
+// names explicitly: the environment of a process is full of unrelated *_FILE
+// paths such as SSL_CERT_FILE.
+func resolveSecretFiles(vars map[string]envVar, allow []string, readSecret func(path string) (string, error)) error {
+	keys := make([]string, 0, len(vars))
+	for key := range vars {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		name, ok := strings.CutSuffix(key, secretFileSuffix)
+		v := vars[key]
+		if !ok || name == "" || !filepath.IsAbs(v.value) {
+			continue
+		}
+		if v.source == sourceInherited && !matchEnvPattern(allow, key) {
+			continue
+		}
+		if _, set := vars[name]; set {
+			continue
+		}
+
+		value, err := readSecret(v.value)
+		if err != nil {
+			return fmt.Errorf("read secret file for %s: %w", name, err)
+		}
+		vars[name] = envVar{value: value, source: v.value}
+	}
+	return nil
+}
+
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
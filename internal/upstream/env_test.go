@@ -0,0 +1,179 @@
+package upstream
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestParseDotenv(t *testing.T) {
+	t.Parallel()
+
+	data := strings.Join([]string{
+		"# comment",
+		"",
+		"export RAILS_ENV=production",
+		"HOST=db.internal # trailing comment",
+		`DATABASE_URL="postgres://${HOST}:${DB_PORT:-5432}/app"`,
+		"LITERAL='${HOST} stays' # comment",
+		`ESCAPED="line\nbreak \"quoted\" \$HOME"`,
+		`BACKSLASH="C:\\$PARENT \\\$PARENT"`,
+		`UNQUOTED=\$PARENT$PARENT`,
+		`MULTI="first`,
+		`second" # comment`,
+		"FROM_PARENT=$PARENT/bin",
+		"EMPTY=",
+	}, "\n")
+
+	lookup := func(name string) (string, bool) {
+		if name == "PARENT" {
+			return "/opt", true
+		}
+		return "", false
+	}
+
+	pairs, err := parseDotenv(data, lookup)
+	if err != nil {
+		t.Fatalf("parseDotenv() error = %v", err)
+	}
+
+	want := [][2]string{
+		{"RAILS_ENV", "production"},
+		{"HOST", "db.internal"},
+		{"DATABASE_URL", "postgres://db.internal:5432/app"},
+		{"LITERAL", "${HOST} stays"},
+		{"ESCAPED", "line\nbreak \"quoted\" $HOME"},
+		{"BACKSLASH", `C:\/opt \$PARENT`},
+		{"UNQUOTED", "$PARENT/opt"},
+		{"MULTI", "first\nsecond"},
+		{"FROM_PARENT", "/opt/bin"},
+		{"EMPTY", ""},
+	}
+	if len(pairs) != len(want) {
+		t.Fatalf("parseDotenv() = %q, want %q", pairs, want)
+	}
+	for i := range want {
+		if pairs[i] != want[i] {
+			t.Errorf("pair %d = %q, want %q", i, pairs[i], want[i])
+		}
+	}
+}
+
+func TestParseDotenvErrors(t *testing.T) {
+	t.Parallel()
+
+	for _, data := range []string{
+		"NO_EQUALS",
+		"1BAD=x",
+		`OPEN="never closed`,
+		"OPEN='never closed",
+		`JUNK="value" junk`,
+	} {
+		if _, err := parseDotenv(data, func(string) (string, bool) { return "", false }); err == nil {
+			t.Errorf("parseDotenv(%q) succeeded, want error", data)
+		}
+	}
+}
+
+func TestResolveEnvPrecedence(t *testing.T) {
+	t.Setenv("UPSTREAM_TEST_KEEP", "parent")
+	t.Setenv("UPSTREAM_TEST_SECRET_TOKEN", "leak")
+	t.Setenv("UPSTREAM_TEST_OVERRIDDEN", "parent")
+
+	dir := t.TempDir()
+	secret := filepath.Join(dir, "db-password")
+	if err := os.WriteFile(secret, []byte("s3cret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	envFile := ".env"
+	if err := os.WriteFile(filepath.Join(dir, envFile), []byte(strings.Join([]string{
+		"UPSTREAM_TEST_OVERRIDDEN=file",
+		"PORT=9999",
+		"EXPANDED=${UPSTREAM_TEST_KEEP}-file",
+		"DB_PASSWORD_FILE=" + secret,
+		"INLINE_WINS=file",
+	}, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Upstream{
+		WorkingDirectory: dir,
+		EnvFiles:         []string{envFile},
+		InheritEnv:       config.InheritEnv{Allow: []string{"UPSTREAM_TEST_*"}, Deny: []string{"*_TOKEN"}},
+		Env:              config.Env{"INLINE_WINS": "inline"},
+	}
+
+	vars, err := resolveEnv(cfg, nil, "tcp", "127.0.0.1:3000")
+	if err != nil {
+		t.Fatalf("resolveEnv() error = %v", err)
+	}
+
+	want := map[string]string{
+		"UPSTREAM_TEST_KEEP":       "parent",
+		"UPSTREAM_TEST_OVERRIDDEN": "file",
+		"PORT":                     "3000",
+		"EXPANDED":                 "parent-file",
+		"DB_PASSWORD":              "s3cret",
+		"INLINE_WINS":              "inline",
+	}
+	for key, value := range want {
+		if got := vars[key].value; got != value {
+			t.Errorf("%s = %q, want %q", key, got, value)
+		}
+	}
+	for _, key := range []string{"UPSTREAM_TEST_SECRET_TOKEN", "PATH"} {
+		if _, ok := vars[key]; ok {
+			t.Errorf("%s was inherited despite inheritEnv", key)
+		}
+	}
+
+	described, err := DescribeEnv(cfg)
+	if err != nil {
+		t.Fatalf("DescribeEnv() error = %v", err)
+	}
+	if got := described["DB_PASSWORD"]; strings.Contains(got, "s3cret") || !strings.Contains(got, secret) {
+		t.Errorf("DescribeEnv() DB_PASSWORD = %q, want redacted value with its source", got)
+	}
+}
+
+func TestDescribeEnvIncludesListenAndUser(t *testing.T) {
+	t.Parallel()
+
+	current, err := user.Current()
+	if err != nil {
+		t.Skip(err)
+	}
+	cfg := config.Upstream{
+		TargetBindSocket: filepath.Join(t.TempDir(), "app.sock"),
+		SocketActivation: true,
+		User:             current.Username,
+		// described without being read
+		Env: config.Env{"API_KEY": "s3cret", "API_TOKEN_FILE": filepath.Join(t.TempDir(), "missing")},
+	}
+
+	described, err := DescribeEnv(cfg)
+	if err != nil {
+		t.Fatalf("DescribeEnv() error = %v", err)
+	}
+	for _, key := range []string{socketEnvKey, "LISTEN_FDS", "LISTEN_FDNAMES", "LISTEN_PID", "HOME", "USER", "LOGNAME", "API_KEY", "API_TOKEN"} {
+		if _, ok := described[key]; !ok {
+			t.Errorf("DescribeEnv() lacks %s", key)
+		}
+	}
+	if got := described[socketEnvKey]; !strings.Contains(got, sourceListen) || strings.Contains(got, "app.sock") {
+		t.Errorf("DescribeEnv() %s = %q, want redacted value with its source", socketEnvKey, got)
+	}
+}
+
+func TestResolveEnvMissingSecretFile(t *testing.T) {
+	t.Parallel()
+
+	cfg := config.Upstream{Env: config.Env{"API_KEY_FILE": filepath.Join(t.TempDir(), "missing")}}
+	if _, err := resolveEnv(cfg, nil, "", ""); err == nil || !strings.Contains(err.Error(), "API_KEY") {
+		t.Fatalf("resolveEnv() error = %v, want secret file error", err)
+	}
+}
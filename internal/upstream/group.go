@@ -0,0 +1,55 @@
+package upstream
+
+import (
+	"context"
+)
+
+// Group is the set of upstream processes that serve proxied traffic. It reports
+// as restarting only while none of them is ready, so one process restarting
+// does not hold back traffic that another can serve.
+type Group []*Server
+
+// Restarting reports whether no process of the group is ready yet but at least
+// one is expected to become ready soon.
+func (g Group) Restarting() bool {
+	restarting := false
+	for _, s := range g {
+		s.mu.Lock()
+		state := s.state
+		s.mu.Unlock()
+
+		switch state {
+		case stateRunning:
+			return false
+		case stateStarting:
+			restarting = true
+		}
+	}
+	return restarting
+}
+
+// WaitReady blocks until any process of the group is ready, all of them have
+// stopped or ctx is done.
+func (g Group) WaitReady(ctx context.Context) error {
+	if len(g) == 1 {
+		return g[0].WaitReady(ctx)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan error, len(g))
+	for _, s := range g {
+		go func(s *Server) {
+			results <- s.WaitReady(ctx)
+		}(s)
+	}
+
+	var err error
+	for range g {
+		if err = <-results; err == nil {
+			return nil
+		}
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"syscall"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+
+	"thrust_oauth2id/internal/config"
+)
+
+const defaultHookTimeout = 5 * time.Minute
+
+// Hook stages.
+const (
+	hookPreStart = "preStart"
+	hookPostStop = "postStop"
+)
+
+// runHooks runs the hooks of a stage one after another and stops at the first
+// failure. ctx cancels a running hook, e.g. when the supervisor is stopped.
+func (s *Server) runHooks(ctx context.Context, stage string, hooks []config.Hook) error {
+	for i, hook := range hooks {
+		if err := s.runHook(ctx, stage, i, hook); err != nil {
+			return fmt.Errorf("upstream %s %s hook %d (%s) failed: %w", s.Name(), stage, i, hook.Command, err)
+		}
+	}
+	return nil
+}
+
+func (s *Server) runHook(ctx context.Context, stage string, index int, hook config.Hook) error {
+	command, args, err := normalizeCommand(hook.Command, hook.Args)
+	if err != nil {
+		return err
+	}
+
+	timeout := time.Duration(hook.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.SysProcAttr = newProcessGroupAttr()
+	cmd.Cancel = func() error {
+		return signalGroup(cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.Dir = s.cfg.WorkingDirectory
+	if hook.WorkingDirectory != "" {
+		cmd.Dir = hook.WorkingDirectory
+	}
+
+	env, err := s.buildEnv("", "")
+	if err != nil {
+		return err
+	}
+	for key, value := range hook.Env {
+		env = append(env, key+"="+value)
+	}
+	cmd.Env = env
+	execThroughShell(cmd, s.attrs.apply(cmd))
+
+	output := s.newProcessOutput(logger.String("hook", stage), logger.Int("hook_index", index))
+	cmd.Stdout = output.stdout
+	cmd.Stderr = output.stderr
+	cmd.WaitDelay = outputWaitDelay
+
+	started := time.Now()
+	logger.Info("running upstream hook",
+		logger.String("name", s.Name()),
+		logger.String("hook", stage),
+		logger.String("command", command),
+		logger.Any("args", args),
+		logger.String("working_dir", cmd.Dir))
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	output.setPID(cmd.Process.Pid)
+	err = waitCommand(cmd)
+	output.flush()
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		return err
+	}
+
+	logger.Info("upstream hook finished",
+		logger.String("name", s.Name()),
+		logger.String("hook", stage),
+		logger.Duration("duration", time.Since(started)))
+	return nil
+}
+
+// stopContext returns a context that is cancelled once Stop is called.
+func (s *Server) stopContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		select {
+		case <-s.stopCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
@@ -0,0 +1,58 @@
+package upstream
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestStartAbortsOnFailingPreStartHook(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(config.Upstream{
+		Enabled: true,
+		Command: os.Args[0],
+		Hooks: config.Hooks{
+			PreStart: []config.Hook{{Command: "/bin/sh -c 'exit 3'"}},
+		},
+	})
+
+	err := s.Start()
+	if err == nil || !strings.Contains(err.Error(), "preStart hook 0") {
+		t.Fatalf("Start() error = %v, want preStart hook failure", err)
+	}
+	if s.Restarting() {
+		t.Fatal("server still reports starting after aborted start")
+	}
+}
+
+func TestStartRunsHooksAroundProcess(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	s := NewServer(config.Upstream{
+		Enabled: true,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestHelperProcess"},
+		Env:     config.Env{"UPSTREAM_HELPER_PROCESS": "1", "UPSTREAM_HELPER_EXIT": "0"},
+		Hooks: config.Hooks{
+			PreStart: []config.Hook{{Command: "/bin/sh -c 'echo $STAGE > pre'", Env: config.Env{"STAGE": "pre"}, WorkingDirectory: dir}},
+			PostStop: []config.Hook{{Command: "/bin/sh -c 'touch " + filepath.Join(dir, "post") + "'"}},
+		},
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	pre, err := os.ReadFile(filepath.Join(dir, "pre"))
+	if err != nil || strings.TrimSpace(string(pre)) != "pre" {
+		t.Fatalf("preStart hook output = %q, %v", pre, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "post")); err != nil {
+		t.Fatalf("postStop hook did not run: %v", err)
+	}
+}
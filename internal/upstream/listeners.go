@@ -0,0 +1,85 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+// inheritedListener is a socket the supervisor listens on itself and hands to
+// every generation bound to its address. Connections arriving while the
+// upstream restarts wait in the kernel backlog instead of being refused.
+type inheritedListener struct {
+	listener net.Listener
+	file     *os.File
+}
+
+// listenerFile returns the descriptor to pass for the address, creating the
+// listener on first use.
+func (s *Server) listenerFile(network, address string) (*os.File, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if l, ok := s.listeners[address]; ok {
+		return l.file, nil
+	}
+
+	if network == "unix" {
+		if err := removeStaleSocket(address); err != nil && !errors.Is(err, os.ErrNotExist) {
+			if errors.Is(err, syscall.EADDRINUSE) {
+				return nil, fmt.Errorf("socket %s is in use by another process", address)
+			}
+			return nil, err
+		}
+	}
+
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("listen on %s: %w", address, err)
+	}
+
+	var file *os.File
+	switch l := listener.(type) {
+	case *net.TCPListener:
+		file, err = l.File()
+	case *net.UnixListener:
+		file, err = l.File()
+	default:
+		err = fmt.Errorf("cannot hand over %s listener", network)
+	}
+	if err != nil {
+		_ = listener.Close()
+		return nil, err
+	}
+
+	if s.listeners == nil {
+		s.listeners = map[string]*inheritedListener{}
+	}
+	s.listeners[address] = &inheritedListener{listener: listener, file: file}
+
+	logger.Info("listening on behalf of upstream",
+		logger.String("name", s.Name()),
+		logger.String("network", network),
+		logger.String("address", address))
+	return file, nil
+}
+
+// closeListeners closes the sockets handed to the upstream once it is stopped
+// for good; unix socket files are removed with them.
+func (s *Server) closeListeners() {
+	s.mu.Lock()
+	listeners := s.listeners
+	s.listeners = nil
+	s.mu.Unlock()
+
+	for address, l := range listeners {
+		_ = l.file.Close()
+		if err := l.listener.Close(); err != nil {
+			logger.Warn("failed to close upstream listener", logger.String("name", s.Name()), logger.String("address", address), logger.Err(err))
+		}
+	}
+}
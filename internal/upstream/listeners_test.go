@@ -0,0 +1,84 @@
+package upstream
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestSocketActivationSurvivesRestart(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "puma.sock")
+	s := NewServer(config.Upstream{
+		Enabled:          true,
+		Command:          os.Args[0],
+		Args:             []string{"-test.run=TestHelperProcess"},
+		Env:              config.Env{"UPSTREAM_HELPER_PROCESS": "1"},
+		TargetBindSocket: socket,
+		SocketActivation: true,
+		Readiness:        config.Readiness{Path: "/up", Timeout: 10},
+		Restart:          config.Restart{Policy: RestartAlways, Backoff: 200},
+	})
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for s.Restarting() || s.WaitReady(ctx) != nil {
+		if ctx.Err() != nil {
+			t.Fatal("upstream did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DisableKeepAlives: true,
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", socket)
+			},
+		},
+	}
+	get := func() int {
+		t.Helper()
+		resp, err := client.Get("http://upstream/")
+		if err != nil {
+			t.Fatalf("request failed: %v", err)
+		}
+		defer resp.Body.Close() //nolint
+		body, _ := io.ReadAll(resp.Body)
+		pid, _ := strconv.Atoi(string(body))
+		return pid
+	}
+
+	first := s.Status().PID
+	if got := get(); got != first {
+		t.Fatalf("served by pid %d, want %d", got, first)
+	}
+
+	// the request is sent while no process is listening and waits in the backlog
+	_ = syscall.Kill(first, syscall.SIGKILL)
+	if got := get(); got == first || got == 0 {
+		t.Fatalf("served by pid %d after restart, want a new process", got)
+	}
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	if err := <-startErr; err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Fatalf("socket left behind after Stop(): %v", err)
+	}
+}
@@ -0,0 +1,29 @@
+package upstream
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Metrics are registered with the default registry and served on /metrics when
+// app.enableMetrics is on.
+var (
+	processExits = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "upstream",
+			Name:      "process_exits_total",
+			Help:      "Total number of upstream process exits that were not requested.",
+		}, []string{"name", "exit_code"},
+	)
+
+	processRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "upstream",
+			Name:      "process_restarts_total",
+			Help:      "Total number of upstream process restarts by the restart policy.",
+		}, []string{"name", "reason"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(processExits, processRestarts)
+}
@@ -0,0 +1,190 @@
+package upstream
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+const (
+	// maxLineBytes bounds a buffered line; longer output is logged in chunks.
+	maxLineBytes = 64 * 1024
+	// outputWaitDelay bounds how long Wait keeps copying output after the
+	// process exited.
+	outputWaitDelay = 5 * time.Second
+)
+
+// waitCommand waits for cmd to exit. A grandchild still holding the output
+// pipes open past WaitDelay does not turn a clean exit into a failure.
+func waitCommand(cmd *exec.Cmd) error {
+	err := cmd.Wait()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		logger.Debug("upstream output still open after exit; stopped copying it", logger.Int("pid", cmd.Process.Pid))
+		return nil
+	}
+	return err
+}
+
+// lineWriter buffers output and hands every complete line to emit. It is safe
+// for concurrent use; exec copies each stream from its own goroutine.
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(line string)
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(string(bytes.TrimSuffix(w.buf[:i], []byte("\r"))))
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) >= maxLineBytes {
+		w.emit(string(w.buf))
+		w.buf = w.buf[:0]
+	}
+	return len(p), nil
+}
+
+// Flush emits a trailing line that was not terminated by a newline.
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(string(w.buf))
+		w.buf = nil
+	}
+}
+
+// processOutput routes the stdout and stderr of one process either straight to
+// ours or, when capture is on, line by line into the structured logger.
+type processOutput struct {
+	stdout, stderr io.Writer
+	pid            atomic.Int64
+	flushers       []*lineWriter
+}
+
+func (s *Server) newProcessOutput(extra ...logger.Field) *processOutput {
+	out := &processOutput{stdout: os.Stdout, stderr: os.Stderr}
+	if !s.cfg.Output.Capture {
+		return out
+	}
+
+	writer := func(stream string) *lineWriter {
+		w := &lineWriter{emit: func(line string) {
+			if line == "" {
+				return
+			}
+			fields := []logger.Field{
+				logger.String("name", s.Name()),
+				logger.Int64("pid", out.pid.Load()),
+				logger.String("stream", stream),
+			}
+			fields = append(fields, extra...)
+			logLine(line, s.cfg.Output.ParseJSON, fields)
+		}}
+		out.flushers = append(out.flushers, w)
+		return w
+	}
+	out.stdout = writer("stdout")
+	out.stderr = writer("stderr")
+	return out
+}
+
+// setPID labels subsequent lines with the pid of the started process.
+func (o *processOutput) setPID(pid int) {
+	o.pid.Store(int64(pid))
+}
+
+func (o *processOutput) flush() {
+	for _, w := range o.flushers {
+		w.Flush()
+	}
+}
+
+// logLine writes one line of child output. JSON lines are merged into the entry
+// when parseJSON is set: their message and level are used and every other key
+// becomes a field.
+func logLine(line string, parseJSON bool, fields []logger.Field) {
+	level, msg := "info", line
+	if parseJSON {
+		if l, m, extra, ok := parseJSONLine(line); ok {
+			level, msg = l, m
+			fields = append(fields, extra...)
+		}
+	}
+
+	switch level {
+	case "debug", "trace":
+		logger.Debug(msg, fields...)
+	case "warn", "warning":
+		logger.Warn(msg, fields...)
+	case "error", "fatal", "panic", "unknown":
+		logger.Error(msg, fields...)
+	default:
+		logger.Info(msg, fields...)
+	}
+}
+
+var (
+	jsonMessageKeys = []string{"message", "msg"}
+	jsonLevelKeys   = []string{"level", "severity", "lvl"}
+)
+
+// parseJSONLine extracts level, message and the remaining fields from a JSON
+// object log line such as those written by lograge or semantic_logger.
+func parseJSONLine(line string) (level, msg string, fields []logger.Field, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") {
+		return "", "", nil, false
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(trimmed), &entry); err != nil {
+		return "", "", nil, false
+	}
+
+	level = "info"
+	for _, key := range jsonLevelKeys {
+		if v, found := entry[key].(string); found {
+			level = strings.ToLower(v)
+			delete(entry, key)
+			break
+		}
+	}
+	for _, key := range jsonMessageKeys {
+		if v, found := entry[key].(string); found {
+			msg = v
+			delete(entry, key)
+			break
+		}
+	}
+
+	keys := make([]string, 0, len(entry))
+	for key := range entry {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fields = append(fields, logger.Any(key, entry[key]))
+	}
+	return level, msg, fields, true
+}
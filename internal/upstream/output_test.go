@@ -0,0 +1,78 @@
+package upstream
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLineWriter(t *testing.T) {
+	t.Parallel()
+
+	var lines []string
+	w := &lineWriter{emit: func(line string) { lines = append(lines, line) }}
+
+	_, _ = w.Write([]byte("Puma starting\r\n* Listening on "))
+	_, _ = w.Write([]byte("unix:///tmp/puma.sock\nUse Ctrl-C"))
+	w.Flush()
+
+	want := []string{"Puma starting", "* Listening on unix:///tmp/puma.sock", "Use Ctrl-C"}
+	if !reflect.DeepEqual(lines, want) {
+		t.Fatalf("lines = %q, want %q", lines, want)
+	}
+
+	lines = nil
+	_, _ = w.Write([]byte(strings.Repeat("x", maxLineBytes)))
+	if len(lines) != 1 || len(lines[0]) != maxLineBytes {
+		t.Fatalf("long line not emitted in a chunk: %d lines", len(lines))
+	}
+}
+
+func TestParseJSONLine(t *testing.T) {
+	t.Parallel()
+
+	level, msg, fields, ok := parseJSONLine(`{"level":"WARN","message":"slow query","duration":1.5,"path":"/users"}`)
+	if !ok {
+		t.Fatal("parseJSONLine() did not parse a JSON object")
+	}
+	if level != "warn" || msg != "slow query" {
+		t.Fatalf("level, msg = %q, %q", level, msg)
+	}
+	keys := make([]string, 0, len(fields))
+	for _, f := range fields {
+		keys = append(keys, f.Key)
+	}
+	if !reflect.DeepEqual(keys, []string{"duration", "path"}) {
+		t.Fatalf("field keys = %v", keys)
+	}
+
+	if _, _, _, ok := parseJSONLine("Started GET \"/\" for 127.0.0.1"); ok {
+		t.Fatal("parseJSONLine() parsed a plain text line")
+	}
+}
+
+func TestWaitCommandIgnoresOutputHeldOpen(t *testing.T) {
+	t.Parallel()
+
+	// the background sleep inherits stdout and keeps the pipe open
+	cmd := exec.Command("sh", "-c", "sleep 5 & exit 0")
+	cmd.Stdout = &bytes.Buffer{}
+	cmd.WaitDelay = 50 * time.Millisecond
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitCommand(cmd); err != nil {
+		t.Fatalf("waitCommand() = %v, want nil", err)
+	}
+
+	cmd = exec.Command("sh", "-c", "exit 3")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if err := waitCommand(cmd); err == nil {
+		t.Fatal("waitCommand() = nil for a failed command")
+	}
+}
@@ -0,0 +1,213 @@
+package upstream
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"thrust_oauth2id/internal/config"
+)
+
+// rlimitUnlimited in the limits configuration lifts the limit entirely.
+const rlimitUnlimited = -1
+
+// processAttrs is what the upstream and its hooks run with beyond the command
+// itself: the user and group to drop to, resource limits and the umask.
+type processAttrs struct {
+	credential *syscall.Credential
+	// home and username are exported as HOME, USER and LOGNAME when dropping to
+	// another user, so tools like bundler do not look into root's home.
+	home     string
+	username string
+	limits   []rlimit
+	umask    string
+}
+
+// rlimit is applied with the shell's ulimit builtin, which takes its value in
+// units of scale bytes.
+type rlimit struct {
+	option   string
+	name     string
+	resource int
+	value    int64
+	scale    int64
+}
+
+// resolveProcessAttrs looks up the configured user and group and checks that
+// this process is able to start the upstream with them and the limits.
+func resolveProcessAttrs(cfg config.Upstream) (*processAttrs, error) {
+	attrs := &processAttrs{}
+
+	if cfg.User != "" || cfg.Group != "" {
+		if err := attrs.resolveCredential(cfg.User, cfg.Group); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, l := range []rlimit{
+		{option: "-n", name: "noFile", resource: unix.RLIMIT_NOFILE, value: cfg.Limits.NoFile, scale: 1},
+		{option: "-c", name: "core", resource: unix.RLIMIT_CORE, value: cfg.Limits.Core, scale: 512},
+		{option: "-v", name: "addressSpace", resource: unix.RLIMIT_AS, value: cfg.Limits.AddressSpace, scale: 1024},
+	} {
+		if l.value == 0 {
+			continue
+		}
+		if err := attrs.checkLimit(l); err != nil {
+			return nil, err
+		}
+		attrs.limits = append(attrs.limits, l)
+	}
+
+	if cfg.Umask != "" {
+		mask, err := strconv.ParseUint(cfg.Umask, 8, 32)
+		if err != nil || mask > 0o777 {
+			return nil, fmt.Errorf("invalid upstream umask %q: must be an octal value between 000 and 777", cfg.Umask)
+		}
+		attrs.umask = fmt.Sprintf("%03o", mask)
+	}
+
+	return attrs, nil
+}
+
+func (a *processAttrs) resolveCredential(username, group string) error {
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	var groups []uint32
+
+	if username != "" {
+		u, err := lookupUser(username)
+		if err != nil {
+			return fmt.Errorf("upstream user %q: %w", username, err)
+		}
+		uid, gid = parseID(u.Uid), parseID(u.Gid)
+		a.home, a.username = u.HomeDir, u.Username
+
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				groups = append(groups, parseID(id))
+			}
+		}
+	}
+
+	if group != "" {
+		g, err := lookupGroup(group)
+		if err != nil {
+			return fmt.Errorf("upstream group %q: %w", group, err)
+		}
+		gid = parseID(g.Gid)
+	}
+
+	if os.Geteuid() != 0 {
+		if uid != uint32(os.Geteuid()) || gid != uint32(os.Getegid()) {
+			return fmt.Errorf("running the upstream as user %q group %q requires root, but this process runs as uid %d",
+				username, group, os.Geteuid())
+		}
+		// already running as them; a credential would make the child call
+		// setgroups, which needs CAP_SETGID
+		return nil
+	}
+
+	a.credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: groups}
+	return nil
+}
+
+// checkLimit fails when the limit is above the hard limit of this process and
+// the shell applying it will not be privileged enough to raise it.
+func (a *processAttrs) checkLimit(l rlimit) error {
+	if l.value < rlimitUnlimited {
+		return fmt.Errorf("invalid upstream limits.%s %d", l.name, l.value)
+	}
+
+	var current unix.Rlimit
+	if err := unix.Getrlimit(l.resource, &current); err != nil {
+		return fmt.Errorf("read limits.%s of this process: %w", l.name, err)
+	}
+
+	privileged := os.Geteuid() == 0 && (a.credential == nil || a.credential.Uid == 0)
+	if privileged || current.Max == unix.RLIM_INFINITY {
+		return nil
+	}
+	if l.value == rlimitUnlimited || uint64(l.value) > current.Max {
+		return fmt.Errorf("upstream limits.%s %s exceeds the hard limit %d of this process", l.name, l.format(), current.Max)
+	}
+	return nil
+}
+
+// apply drops privileges and returns the shell steps that set the limits and
+// umask, see execThroughShell.
+func (a *processAttrs) apply(cmd *exec.Cmd) []string {
+	if a == nil {
+		return nil
+	}
+	if a.credential != nil {
+		cmd.SysProcAttr.Credential = a.credential
+	}
+
+	steps := make([]string, 0, len(a.limits)+1)
+	for _, l := range a.limits {
+		steps = append(steps, "ulimit "+l.option+" "+l.shellValue())
+	}
+	if a.umask != "" {
+		steps = append(steps, "umask "+a.umask)
+	}
+	return steps
+}
+
+// execThroughShell wraps the command in a shell that runs steps before
+// exec'ing the command in place, so the pid of the upstream stays the same.
+// This is how the child gets settings that exec.Cmd cannot express.
+func execThroughShell(cmd *exec.Cmd, steps []string) {
+	if len(steps) == 0 || cmd.Err != nil {
+		return
+	}
+
+	script := strings.Join(append(steps, `exec "$@"`), " && ")
+	cmd.Args = append([]string{"/bin/sh", "-c", script, "sh", cmd.Path}, cmd.Args[1:]...)
+	cmd.Path = "/bin/sh"
+}
+
+// env returns the variables describing the user the upstream runs as.
+func (a *processAttrs) env() map[string]string {
+	if a == nil || a.username == "" {
+		return nil
+	}
+	return map[string]string{"HOME": a.home, "USER": a.username, "LOGNAME": a.username}
+}
+
+func (l rlimit) shellValue() string {
+	if l.value == rlimitUnlimited {
+		return "unlimited"
+	}
+	return strconv.FormatInt((l.value+l.scale-1)/l.scale, 10)
+}
+
+func (l rlimit) format() string {
+	if l.value == rlimitUnlimited {
+		return "unlimited"
+	}
+	return strconv.FormatInt(l.value, 10)
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+	return user.LookupGroup(name)
+}
+
+func parseID(id string) uint32 {
+	n, _ := strconv.ParseUint(id, 10, 32)
+	return uint32(n)
+}
@@ -0,0 +1,119 @@
+package upstream
+
+import (
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestResolveProcessAttrsValidates(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		cfg     config.Upstream
+		wantErr string
+	}{
+		{name: "unknown user", cfg: config.Upstream{User: "no-such-user-for-upstream"}, wantErr: `upstream user "no-such-user-for-upstream"`},
+		{name: "unknown group", cfg: config.Upstream{Group: "no-such-group-for-upstream"}, wantErr: `upstream group "no-such-group-for-upstream"`},
+		{name: "invalid umask", cfg: config.Upstream{Umask: "089"}, wantErr: "invalid upstream umask"},
+		{name: "umask out of range", cfg: config.Upstream{Umask: "1777"}, wantErr: "invalid upstream umask"},
+		{name: "negative limit", cfg: config.Upstream{Limits: config.Limits{NoFile: -2}}, wantErr: "invalid upstream limits.noFile"},
+		{name: "defaults", cfg: config.Upstream{}},
+		{name: "umask", cfg: config.Upstream{Umask: "027"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := resolveProcessAttrs(tt.cfg)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("resolveProcessAttrs() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("resolveProcessAttrs() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestResolveProcessAttrsRejectsLimitAboveHardLimit(t *testing.T) {
+	t.Parallel()
+
+	var current unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &current); err != nil || current.Max == unix.RLIM_INFINITY {
+		t.Skip("no finite hard limit on open files")
+	}
+
+	cfg := config.Upstream{Limits: config.Limits{NoFile: int64(current.Max) + 1}}
+	if os.Geteuid() == 0 {
+		// root may raise its own hard limit, but not after dropping privileges
+		if _, err := user.Lookup("nobody"); err != nil {
+			t.Skip("no unprivileged user to drop to")
+		}
+		cfg.User = "nobody"
+	}
+
+	_, err := resolveProcessAttrs(cfg)
+	if err == nil || !strings.Contains(err.Error(), "exceeds the hard limit") {
+		t.Fatalf("resolveProcessAttrs() error = %v, want hard limit error", err)
+	}
+}
+
+func TestStartAsOwnUserWithoutRoot(t *testing.T) {
+	t.Parallel()
+
+	if os.Geteuid() == 0 {
+		t.Skip("root may always set a credential")
+	}
+	self, err := user.Current()
+	if err != nil {
+		t.Skip("cannot look up the current user")
+	}
+
+	s := NewServer(config.Upstream{
+		Enabled: true,
+		Command: "/bin/sh",
+		Args:    []string{"-c", "exit 0"},
+		User:    self.Username,
+		Restart: config.Restart{Policy: RestartNever},
+	})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+}
+
+func TestStartAppliesLimitsAndUmask(t *testing.T) {
+	t.Parallel()
+
+	out := filepath.Join(t.TempDir(), "out")
+	s := NewServer(config.Upstream{
+		Enabled: true,
+		Command: "/bin/sh",
+		Args:    []string{"-c", "ulimit -n > " + out + "; umask >> " + out},
+		Limits:  config.Limits{NoFile: 256},
+		Umask:   "027",
+		Restart: config.Restart{Policy: RestartNever},
+	})
+
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	data, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) != 2 || lines[0] != "256" || !strings.HasSuffix(lines[1], "027") {
+		t.Fatalf("upstream saw limits and umask %q, want 256 and 027", lines)
+	}
+}
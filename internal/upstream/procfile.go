@@ -0,0 +1,124 @@
+package upstream
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"thrust_oauth2id/internal/config"
+)
+
+// defaultName names the single upstream of the legacy upstream block and the
+// Procfile entry that receives the proxied traffic.
+const defaultName = "web"
+
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_-]+):\s*(.+)$`)
+
+// ProcfileEntry is one "name: command" line of a Procfile.
+type ProcfileEntry struct {
+	Name    string
+	Command string
+}
+
+// ParseProcfile reads a foreman-style Procfile. Blank lines and lines starting
+// with # are ignored.
+func ParseProcfile(path string) ([]ProcfileEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close() //nolint
+
+	var entries []ProcfileEntry
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		m := procfileLine.FindStringSubmatch(line)
+		if m == nil {
+			return nil, fmt.Errorf("%s:%d: expected \"name: command\"", path, lineNo)
+		}
+		entries = append(entries, ProcfileEntry{Name: m[1], Command: strings.TrimSpace(m[2])})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ResolveConfigs returns the upstream processes to supervise. The upstreams
+// list and the Procfile take precedence over the single upstream block; Procfile
+// entries inherit the settings of the upstream block except the command. Only
+// the "web" entry binds targetPort/targetBindSocket, receives traffic and keeps
+// what belongs to the application server: hooks, which then run once for the
+// whole Procfile, pidFile and reload.
+func ResolveConfigs(cfg *config.Config) ([]config.Upstream, error) {
+	var resolved []config.Upstream
+
+	switch {
+	case len(cfg.Upstreams) > 0 && cfg.Procfile != "":
+		return nil, fmt.Errorf("configure either upstreams or procfile, not both")
+
+	case len(cfg.Upstreams) > 0:
+		for i, u := range cfg.Upstreams {
+			if u.Name == "" {
+				return nil, fmt.Errorf("upstreams[%d]: name is required", i)
+			}
+			u.Enabled = true
+			resolved = append(resolved, u)
+		}
+
+	case cfg.Procfile != "":
+		entries, err := ParseProcfile(cfg.Procfile)
+		if err != nil {
+			return nil, fmt.Errorf("read procfile: %w", err)
+		}
+		for _, entry := range entries {
+			u := cfg.Upstream
+			u.Enabled = true
+			u.Name = entry.Name
+			u.Command = entry.Command
+			u.Args = nil
+			u.Proxy = entry.Name == defaultName
+			if !u.Proxy {
+				u.TargetPort = 0
+				u.TargetBindSocket = ""
+				u.Hooks = config.Hooks{}
+				u.PidFile = ""
+				u.Reload = config.Reload{}
+			}
+			resolved = append(resolved, u)
+		}
+
+	case cfg.Upstream.Enabled:
+		u := cfg.Upstream
+		if u.Name == "" {
+			u.Name = defaultName
+		}
+		u.Proxy = true
+		resolved = append(resolved, u)
+	}
+
+	seen := make(map[string]bool, len(resolved))
+	for _, u := range resolved {
+		if seen[u.Name] {
+			return nil, fmt.Errorf("duplicate upstream name %q", u.Name)
+		}
+		seen[u.Name] = true
+		if u.Command == "" {
+			return nil, fmt.Errorf("upstream %q: command not configured", u.Name)
+		}
+		// the proxy only follows a reload to the alternate address when it
+		// takes its targets from the upstreams
+		if u.Proxy && u.Reload.Enabled && cfg.Proxy.Enabled && len(cfg.Proxy.Targets) > 0 {
+			return nil, fmt.Errorf("upstream %q: reload moves the upstream to another address, which proxy.targets does not follow; "+
+				"leave proxy.targets empty to proxy to the upstream", u.Name)
+		}
+	}
+	return resolved, nil
+}
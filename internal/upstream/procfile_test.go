@@ -0,0 +1,128 @@
+package upstream
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func writeProcfile(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "Procfile")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseProcfile(t *testing.T) {
+	t.Parallel()
+
+	path := writeProcfile(t, "# processes\nweb: bundle exec puma -C config/puma.rb\n\nworker:bin/jobs\n")
+	entries, err := ParseProcfile(path)
+	if err != nil {
+		t.Fatalf("ParseProcfile() error = %v", err)
+	}
+
+	want := []ProcfileEntry{
+		{Name: "web", Command: "bundle exec puma -C config/puma.rb"},
+		{Name: "worker", Command: "bin/jobs"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("ParseProcfile() = %v, want %v", entries, want)
+	}
+	for i := range want {
+		if entries[i] != want[i] {
+			t.Fatalf("ParseProcfile()[%d] = %v, want %v", i, entries[i], want[i])
+		}
+	}
+
+	if _, err := ParseProcfile(writeProcfile(t, "just a command\n")); err == nil {
+		t.Fatal("ParseProcfile() expected error for a line without a name")
+	}
+}
+
+func TestResolveConfigs(t *testing.T) {
+	t.Parallel()
+
+	base := config.Upstream{
+		Enabled:          true,
+		Command:          "bin/rails server",
+		TargetBindSocket: "/tmp/puma.sock",
+		StopSignal:       "SIGINT",
+		PidFile:          "tmp/pids/server.pid",
+		Hooks:            config.Hooks{PreStart: []config.Hook{{Command: "bin/rails db:prepare"}}},
+		Reload:           config.Reload{Enabled: true},
+	}
+
+	t.Run("legacy single upstream", func(t *testing.T) {
+		got, err := ResolveConfigs(&config.Config{Upstream: base})
+		if err != nil {
+			t.Fatalf("ResolveConfigs() error = %v", err)
+		}
+		if len(got) != 1 || got[0].Name != "web" || !got[0].Proxy {
+			t.Fatalf("ResolveConfigs() = %+v", got)
+		}
+	})
+
+	t.Run("procfile inherits upstream settings", func(t *testing.T) {
+		cfg := &config.Config{Upstream: base, Procfile: writeProcfile(t, "web: bin/rails server\nworker: bin/jobs\n")}
+		got, err := ResolveConfigs(cfg)
+		if err != nil {
+			t.Fatalf("ResolveConfigs() error = %v", err)
+		}
+		if len(got) != 2 {
+			t.Fatalf("ResolveConfigs() = %+v", got)
+		}
+		if !got[0].Proxy || got[0].TargetBindSocket != "/tmp/puma.sock" {
+			t.Fatalf("web entry = %+v", got[0])
+		}
+		if got[1].Proxy || got[1].TargetBindSocket != "" || got[1].StopSignal != "SIGINT" || got[1].Command != "bin/jobs" {
+			t.Fatalf("worker entry = %+v", got[1])
+		}
+		if got[0].PidFile == "" || len(got[0].Hooks.PreStart) != 1 || !got[0].Reload.Enabled {
+			t.Fatalf("web entry lost the application server settings: %+v", got[0])
+		}
+		// the worker must neither rerun the hooks nor stop the web process under its pidfile
+		if got[1].PidFile != "" || len(got[1].Hooks.PreStart) != 0 || got[1].Reload.Enabled {
+			t.Fatalf("worker entry inherited the application server settings: %+v", got[1])
+		}
+	})
+
+	t.Run("rejects duplicate names", func(t *testing.T) {
+		cfg := &config.Config{Upstreams: []config.Upstream{
+			{Name: "web", Command: "a"},
+			{Name: "web", Command: "b"},
+		}}
+		if _, err := ResolveConfigs(cfg); err == nil {
+			t.Fatal("ResolveConfigs() expected duplicate name error")
+		}
+	})
+
+	t.Run("rejects reload with explicit proxy targets", func(t *testing.T) {
+		reloading := base
+		reloading.Reload.Enabled = true
+		cfg := &config.Config{
+			Upstream: reloading,
+			Proxy:    config.Proxy{Enabled: true, Targets: []config.Target{{URL: "http://127.0.0.1:3000"}}},
+		}
+		if _, err := ResolveConfigs(cfg); err == nil {
+			t.Fatal("ResolveConfigs() expected error")
+		}
+
+		cfg.Proxy.Targets = nil
+		if _, err := ResolveConfigs(cfg); err != nil {
+			t.Fatalf("ResolveConfigs() error = %v", err)
+		}
+	})
+
+	t.Run("rejects both list and procfile", func(t *testing.T) {
+		cfg := &config.Config{Procfile: "Procfile", Upstreams: []config.Upstream{{Name: "web", Command: "a"}}}
+		if _, err := ResolveConfigs(cfg); err == nil {
+			t.Fatal("ResolveConfigs() expected error")
+		}
+	})
+}
@@ -0,0 +1,170 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+// ErrReloadInProgress is returned by Reload while another reload is running.
+var ErrReloadInProgress = errors.New("upstream reload already in progress")
+
+var (
+	errReloadDisabled = errors.New("upstream reload is not enabled")
+	errStillStarting  = errors.New("upstream process is still starting")
+)
+
+// ReloadResult describes a completed phased restart.
+type ReloadResult struct {
+	Name     string        `json:"name"`
+	OldPID   int           `json:"oldPid"`
+	NewPID   int           `json:"newPid"`
+	Address  string        `json:"address"`
+	Duration time.Duration `json:"duration" swaggertype:"integer"` // nanoseconds
+}
+
+// OnSwitch registers fn to be called with the listen address of a new
+// generation right after it takes over, before the old one is stopped.
+func (s *Server) OnSwitch(fn func(network, address string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onSwitch = append(s.onSwitch, fn)
+}
+
+// ReloadSignals returns the signals that should trigger Reload, or nil when
+// phased restarts are disabled.
+func (s *Server) ReloadSignals() []os.Signal {
+	if !s.cfg.Reload.Enabled {
+		return nil
+	}
+
+	names := s.cfg.Reload.Signals
+	if len(names) == 0 {
+		names = []string{"SIGHUP", "SIGUSR2"}
+	}
+
+	signals := make([]os.Signal, 0, len(names))
+	for _, name := range names {
+		sig, err := ParseSignal(name)
+		if err != nil {
+			logger.Warn("ignoring invalid upstream reload signal", logger.String("signal", name), logger.Err(err))
+			continue
+		}
+		signals = append(signals, sig)
+	}
+	return signals
+}
+
+// Reload performs a blue/green restart: it starts a new generation of the
+// command on the alternate socket or port, waits for it to pass the readiness
+// probe, switches traffic to it and then gracefully stops the old generation.
+// The old generation keeps serving if the new one fails to become ready.
+func (s *Server) Reload(ctx context.Context) (*ReloadResult, error) {
+	if !s.cfg.Reload.Enabled {
+		return nil, errReloadDisabled
+	}
+	if !s.reloadMu.TryLock() {
+		return nil, ErrReloadInProgress
+	}
+	defer s.reloadMu.Unlock()
+
+	s.mu.Lock()
+	old, state, stopping, slot := s.current, s.state, s.stopping, 1-s.slot
+	s.mu.Unlock()
+
+	switch {
+	case old == nil || stopping:
+		return nil, errNotRunning
+	case state != stateRunning:
+		return nil, errStillStarting
+	}
+
+	started := time.Now()
+	// A reload usually follows a deploy, so migrations and asset builds run
+	// first while the current generation keeps serving.
+	if err := s.runHooks(ctx, hookPreStart, s.cfg.Hooks.PreStart); err != nil {
+		return nil, err
+	}
+
+	network, address := s.listenAddress(slot)
+	if network == "unix" && !s.cfg.SocketActivation {
+		// A previous generation that crashed on this slot may have left its socket behind.
+		_ = removeStaleSocket(address)
+	}
+
+	proc, err := s.spawn(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		_ = s.stopProcess(proc)
+		return nil, errNotRunning
+	}
+	s.pending = proc
+	s.mu.Unlock()
+
+	readyCtx, cancel := context.WithTimeout(ctx, s.readinessTimeout())
+	err = s.waitHealthy(readyCtx, proc)
+	cancel()
+
+	if err != nil {
+		s.mu.Lock()
+		s.pending = nil
+		s.mu.Unlock()
+
+		logger.Warn("new upstream process failed readiness check; keeping the current one",
+			logger.String("name", s.Name()),
+			logger.Int("pid", proc.pid()),
+			logger.String("address", address),
+			logger.Err(err))
+		if stopErr := s.stopProcess(proc); stopErr != nil {
+			logger.Warn("failed to stop unready upstream process", logger.String("name", s.Name()), logger.Int("pid", proc.pid()), logger.Err(stopErr))
+		}
+		return nil, fmt.Errorf("new upstream process not ready: %w", err)
+	}
+	proc.markReady()
+
+	s.mu.Lock()
+	s.pending = nil
+	if s.stopping || s.current != old {
+		s.mu.Unlock()
+		_ = s.stopProcess(proc)
+		return nil, errNotRunning
+	}
+	s.current = proc
+	s.slot = slot
+	s.state = stateRunning
+	listeners := make([]func(network, address string), len(s.onSwitch))
+	copy(listeners, s.onSwitch)
+	s.mu.Unlock()
+
+	for _, fn := range listeners {
+		fn(network, address)
+	}
+
+	logger.Info("switched traffic to new upstream process",
+		logger.String("name", s.Name()),
+		logger.Int("old_pid", old.pid()),
+		logger.Int("new_pid", proc.pid()),
+		logger.String("address", address))
+
+	if err := s.stopProcess(old); err != nil {
+		logger.Warn("failed to stop previous upstream process", logger.String("name", s.Name()), logger.Int("pid", old.pid()), logger.Err(err))
+	}
+
+	return &ReloadResult{
+		Name:     s.Name(),
+		OldPID:   old.pid(),
+		NewPID:   proc.pid(),
+		Address:  address,
+		Duration: time.Since(started),
+	}, nil
+}
@@ -0,0 +1,159 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"thrust_oauth2id/internal/config"
+)
+
+// TestHelperProcess is not a real test; it stands in for the upstream app when
+// re-executed by the reload tests.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("UPSTREAM_HELPER_PROCESS") != "1" {
+		return
+	}
+	if code := os.Getenv("UPSTREAM_HELPER_EXIT"); code != "" {
+		n, _ := strconv.Atoi(code)
+		os.Exit(n)
+	}
+
+	if path := os.Getenv("UPSTREAM_HELPER_CHILD_PIDFILE"); path != "" {
+		child := exec.Command("sleep", "60")
+		if child.Start() != nil || os.WriteFile(path, []byte(strconv.Itoa(child.Process.Pid)), 0o600) != nil {
+			os.Exit(2)
+		}
+	}
+
+	var ln net.Listener
+	var err error
+	if os.Getenv("LISTEN_FDS") == "1" {
+		if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+			os.Exit(3)
+		}
+		ln, err = net.FileListener(os.NewFile(3, "listener"))
+	} else {
+		ln, err = net.Listen("tcp", net.JoinHostPort("127.0.0.1", os.Getenv("PORT")))
+	}
+	if err != nil {
+		os.Exit(2)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, os.Getpid())
+	})}
+	go func() { _ = server.Serve(ln) }()
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGTERM)
+	<-sig
+	_ = server.Close()
+	os.Exit(0)
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close() //nolint
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+func TestListenAddressSlots(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(config.Upstream{TargetPort: 3000})
+	if _, address := s.listenAddress(1); address != "127.0.0.1:3001" {
+		t.Fatalf("alternate port = %q, want 127.0.0.1:3001", address)
+	}
+
+	s = NewServer(config.Upstream{TargetBindSocket: "unix:///tmp/puma.sock"})
+	if network, address := s.listenAddress(0); network != "unix" || address != "/tmp/puma.sock" {
+		t.Fatalf("primary socket = %s %q", network, address)
+	}
+	if _, address := s.listenAddress(1); address != "/tmp/puma.next.sock" {
+		t.Fatalf("alternate socket = %q, want /tmp/puma.next.sock", address)
+	}
+
+	s = NewServer(config.Upstream{TargetBindSocket: "/tmp/puma.sock", Reload: config.Reload{AlternateSocket: "/tmp/blue.sock"}})
+	if _, address := s.listenAddress(1); address != "/tmp/blue.sock" {
+		t.Fatalf("configured alternate socket = %q", address)
+	}
+}
+
+func TestReloadSwitchesToNewProcess(t *testing.T) {
+	s := NewServer(config.Upstream{
+		Enabled:    true,
+		Command:    os.Args[0],
+		Args:       []string{"-test.run=TestHelperProcess"},
+		Env:        config.Env{"UPSTREAM_HELPER_PROCESS": "1"},
+		TargetPort: freePort(t),
+		Readiness:  config.Readiness{Path: "/up", Timeout: 10},
+		Reload: config.Reload{
+			Enabled:       true,
+			AlternatePort: freePort(t),
+		},
+	})
+
+	var switched []string
+	s.OnSwitch(func(_, address string) {
+		switched = append(switched, address)
+	})
+
+	startErr := make(chan error, 1)
+	go func() { startErr <- s.Start() }()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for s.Restarting() || s.WaitReady(ctx) != nil {
+		if ctx.Err() != nil {
+			t.Fatal("upstream did not become ready")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	result, err := s.Reload(ctx)
+	if err != nil {
+		t.Fatalf("Reload() error = %v", err)
+	}
+	if result.OldPID == result.NewPID {
+		t.Fatalf("Reload() kept pid %d", result.NewPID)
+	}
+	_, wantAddress := s.listenAddress(1)
+	if len(switched) != 1 || switched[0] != wantAddress {
+		t.Fatalf("switch callbacks = %v, want [%s]", switched, wantAddress)
+	}
+
+	resp, err := http.Get("http://" + wantAddress)
+	if err != nil {
+		t.Fatalf("new generation not serving: %v", err)
+	}
+	resp.Body.Close() //nolint
+
+	if err := s.Stop(); err != nil {
+		t.Fatalf("Stop() error = %v", err)
+	}
+	select {
+	case err := <-startErr:
+		if err != nil {
+			t.Fatalf("Start() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not return after Stop()")
+	}
+
+	if _, err := s.Reload(context.Background()); err == nil {
+		t.Fatal("Reload() after Stop() should fail")
+	}
+}
@@ -0,0 +1,127 @@
+package upstream
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"thrust_oauth2id/internal/config"
+)
+
+// Restart policies understood by the supervisor.
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+const (
+	defaultRestartBackoff     = time.Second
+	defaultRestartMaxBackoff  = 30 * time.Second
+	defaultRestartMaxRestarts = 5
+	defaultRestartWindow      = time.Minute
+)
+
+var errTooManyRestarts = errors.New("upstream process restarted too often")
+
+// restartPolicy decides whether and when an exited process is started again.
+// It is only used by the Start loop and needs no locking.
+type restartPolicy struct {
+	policy      string
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	maxRestarts int
+	window      time.Duration
+
+	// attempt counts restarts since the process last ran for a full window.
+	attempt int
+	history []time.Time
+}
+
+func newRestartPolicy(cfg config.Restart) *restartPolicy {
+	p := &restartPolicy{
+		policy:      cfg.Policy,
+		backoff:     time.Duration(cfg.Backoff) * time.Millisecond,
+		maxBackoff:  time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		maxRestarts: cfg.MaxRestarts,
+		window:      time.Duration(cfg.Window) * time.Second,
+	}
+	if p.policy == "" {
+		p.policy = RestartNever
+	}
+	if p.backoff <= 0 {
+		p.backoff = defaultRestartBackoff
+	}
+	if p.maxBackoff <= 0 {
+		p.maxBackoff = defaultRestartMaxBackoff
+	}
+	if p.maxRestarts <= 0 {
+		p.maxRestarts = defaultRestartMaxRestarts
+	}
+	if p.window <= 0 {
+		p.window = defaultRestartWindow
+	}
+	return p
+}
+
+func validateRestartPolicy(policy string) error {
+	switch policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+		return nil
+	}
+	return fmt.Errorf("unsupported restart policy %q", policy)
+}
+
+// shouldRestart reports whether an exited process is restarted.
+func (p *restartPolicy) shouldRestart(failed bool) bool {
+	switch p.policy {
+	case RestartAlways:
+		return true
+	case RestartOnFailure:
+		return failed
+	default:
+		return false
+	}
+}
+
+// next records a restart at now and returns how long to wait before it. A
+// process that stayed up for a full window resets the backoff. It fails once
+// more than maxRestarts restarts fall within the window.
+func (p *restartPolicy) next(now time.Time, uptime time.Duration) (time.Duration, error) {
+	if uptime >= p.window {
+		p.attempt = 0
+	}
+
+	recent := p.history[:0]
+	for _, at := range p.history {
+		if now.Sub(at) < p.window {
+			recent = append(recent, at)
+		}
+	}
+	p.history = recent
+	if len(p.history) >= p.maxRestarts {
+		return 0, fmt.Errorf("%w: %d restarts within %s", errTooManyRestarts, len(p.history), p.window)
+	}
+	p.history = append(p.history, now)
+
+	delay := p.backoff << p.attempt
+	if delay <= 0 || delay > p.maxBackoff {
+		delay = p.maxBackoff
+	}
+	p.attempt++
+	return delay, nil
+}
+
+// exitCode returns the exit code of a finished command, -1 when it was killed
+// by a signal or never ran.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
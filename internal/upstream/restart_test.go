@@ -0,0 +1,116 @@
+package upstream
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestRestartPolicyShouldRestart(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		policy  string
+		failed  bool
+		restart bool
+	}{
+		{policy: "", failed: true, restart: false},
+		{policy: RestartNever, failed: true, restart: false},
+		{policy: RestartOnFailure, failed: true, restart: true},
+		{policy: RestartOnFailure, failed: false, restart: false},
+		{policy: RestartAlways, failed: false, restart: true},
+	}
+
+	for _, tc := range testCases {
+		p := newRestartPolicy(config.Restart{Policy: tc.policy})
+		if got := p.shouldRestart(tc.failed); got != tc.restart {
+			t.Fatalf("policy %q failed=%v: shouldRestart() = %v, want %v", tc.policy, tc.failed, got, tc.restart)
+		}
+	}
+}
+
+func TestRestartPolicyBackoffAndCircuit(t *testing.T) {
+	t.Parallel()
+
+	p := newRestartPolicy(config.Restart{Policy: RestartAlways, Backoff: 100, MaxBackoff: 300, MaxRestarts: 3, Window: 10})
+	now := time.Now()
+
+	var delays []time.Duration
+	for i := 0; i < 3; i++ {
+		delay, err := p.next(now, time.Second)
+		if err != nil {
+			t.Fatalf("next() #%d error = %v", i, err)
+		}
+		delays = append(delays, delay)
+	}
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond}
+	for i := range want {
+		if delays[i] != want[i] {
+			t.Fatalf("delays = %v, want %v", delays, want)
+		}
+	}
+
+	if _, err := p.next(now, time.Second); !errors.Is(err, errTooManyRestarts) {
+		t.Fatalf("next() error = %v, want errTooManyRestarts", err)
+	}
+
+	// once the window has passed the history is forgotten and a long-lived
+	// process resets the backoff
+	delay, err := p.next(now.Add(11*time.Second), 11*time.Second)
+	if err != nil || delay != 100*time.Millisecond {
+		t.Fatalf("next() after window = %v, %v", delay, err)
+	}
+}
+
+func TestStartGivesUpAfterMaxRestarts(t *testing.T) {
+	s := NewServer(config.Upstream{
+		Name:    "give-up",
+		Enabled: true,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestHelperProcess"},
+		Env:     config.Env{"UPSTREAM_HELPER_PROCESS": "1", "UPSTREAM_HELPER_EXIT": "3"},
+		Restart: config.Restart{Policy: RestartOnFailure, Backoff: 10, MaxRestarts: 2, Window: 60},
+	})
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start() }()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, errTooManyRestarts) {
+			t.Fatalf("Start() error = %v, want errTooManyRestarts", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("Start() did not give up")
+	}
+	// the first run, both restarts and the exit that gave up
+	if got := testutil.ToFloat64(processExits.WithLabelValues("give-up", "3")); got != 3 {
+		t.Fatalf("process exits = %v, want 3", got)
+	}
+}
+
+func TestStartCountsExitWithoutRestart(t *testing.T) {
+	s := NewServer(config.Upstream{
+		Name:    "no-restart",
+		Enabled: true,
+		Command: os.Args[0],
+		Args:    []string{"-test.run=TestHelperProcess"},
+		Env:     config.Env{"UPSTREAM_HELPER_PROCESS": "1", "UPSTREAM_HELPER_EXIT": "3"},
+		Restart: config.Restart{Policy: RestartNever},
+	})
+
+	if err := s.Start(); err == nil {
+		t.Fatal("Start() error = nil, want exit error")
+	}
+	if got := testutil.ToFloat64(processExits.WithLabelValues("no-restart", "3")); got != 1 {
+		t.Fatalf("process exits = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(processRestarts.WithLabelValues("no-restart", "failure")); got != 0 {
+		t.Fatalf("process restarts = %v, want 0", got)
+	}
+}
@@ -1,6 +1,7 @@
 package upstream
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -8,6 +9,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unicode"
@@ -17,7 +19,13 @@ import (
 	"thrust_oauth2id/internal/config"
 )
 
-const defaultStopTimeout = 10 * time.Second
+const (
+	defaultStopTimeout = 10 * time.Second
+
+	// socketEnvKey tells the upstream which UNIX socket to bind, e.g. in puma.rb:
+	//   bind "unix://#{ENV.fetch("UPSTREAM_SOCKET")}"
+	socketEnvKey = "UPSTREAM_SOCKET"
+)
 
 var errEmptyCommand = errors.New("upstream command is empty")
 
@@ -25,172 +33,472 @@ var errEmptyCommand = errors.New("upstream command is empty")
 type Server struct {
 	cfg      config.Upstream
 	mu       sync.Mutex
-	cmd      *exec.Cmd
-	done     chan struct{}
+	current  *process
+	state    int
 	stopping bool
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	attrs    *processAttrs
+	// listeners are the sockets handed to the upstream with socket
+	// activation, by address; they outlive every generation.
+	listeners map[string]*inheritedListener
+
+	// reloadMu serialises reloads; pending is the generation a reload is
+	// bringing up and slot the listen address of the current generation.
+	reloadMu sync.Mutex
+	pending  *process
+	slot     int
+	onSwitch []func(network, address string)
+}
+
+// process is one generation of the supervised command.
+type process struct {
+	cmd       *exec.Cmd
+	started   time.Time
+	network   string
+	address   string
+	done      chan struct{}
+	ready     chan struct{}
+	readyOnce sync.Once
+	err       error
+	// unready is set when the process was stopped for missing the readiness timeout.
+	unready atomic.Bool
+}
+
+// failed reports whether the process exited with an error or was stopped
+// because it never became ready.
+func (p *process) failed() bool {
+	return p.err != nil || p.unready.Load()
+}
+
+func (p *process) pid() int {
+	return p.cmd.Process.Pid
+}
+
+func (p *process) markReady() {
+	p.readyOnce.Do(func() {
+		close(p.ready)
+	})
 }
 
 // NewServer creates a supervisor for the configured upstream command.
 func NewServer(cfg config.Upstream) *Server {
-	return &Server{cfg: cfg}
+	return &Server{cfg: cfg, stopCh: make(chan struct{})}
 }
 
-// Start launches the upstream command and blocks until it exits.
+// Start launches the upstream command and blocks until it exits for good: it
+// was stopped, exited and the restart policy does not restart it, or it
+// restarted too often within the configured window.
 func (s *Server) Start() error {
 	if s.cfg.Enabled && s.cfg.Command == "" {
 		return errEmptyCommand
 	}
-
-	command, args, err := normalizeCommand(s.cfg.Command, s.cfg.Args)
+	if err := validateRestartPolicy(s.cfg.Restart.Policy); err != nil {
+		return err
+	}
+	attrs, err := resolveProcessAttrs(s.cfg)
 	if err != nil {
-		return fmt.Errorf("prepare upstream command: %w", err)
+		return err
 	}
 
-	cmd := exec.Command(command, args...)
-	if s.cfg.Enabled && s.cfg.WorkingDirectory != "" {
-		if _, err := os.Stat(s.cfg.WorkingDirectory); err != nil {
-			if os.IsNotExist(err) {
-				return fmt.Errorf("working directory does not exist: %s", s.cfg.WorkingDirectory)
-			}
-			return fmt.Errorf("cannot access working directory %s: %w", s.cfg.WorkingDirectory, err)
+	s.mu.Lock()
+	s.attrs = attrs
+	s.state = stateStarting
+	s.mu.Unlock()
+
+	ctx, cancel := s.stopContext()
+	err = s.runHooks(ctx, hookPreStart, s.cfg.Hooks.PreStart)
+	cancel()
+	if err != nil {
+		s.setStopped()
+		if s.isStopping() {
+			return nil
 		}
-		cmd.Dir = s.cfg.WorkingDirectory
+		return err
 	}
+	defer s.runPostStopHooks()
+	defer s.closeListeners()
 
-	cmd.Env = s.buildEnv()
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	done := make(chan struct{})
+	network, address := s.listenAddress(0)
+	s.cleanupStale(network, address)
+	proc, err := s.spawn(network, address)
+	if err != nil {
+		s.setStopped()
+		return err
+	}
 
 	s.mu.Lock()
-	s.cmd = cmd
-	s.done = done
-	s.stopping = false
+	if s.stopping {
+		s.mu.Unlock()
+		_ = s.stopProcess(proc)
+		s.setStopped()
+		return nil
+	}
+	s.current = proc
+	s.slot = 0
 	s.mu.Unlock()
 
-	defer func() {
-		close(done)
+	go s.watchAvailability(proc)
+
+	policy := newRestartPolicy(s.cfg.Restart)
+	for {
 		s.mu.Lock()
-		s.cmd = nil
-		s.done = nil
+		proc = s.current
 		s.mu.Unlock()
-	}()
 
-	if err := cmd.Start(); err != nil {
-		return fmt.Errorf("start upstream command: %w", err)
+		<-proc.done
+
+		s.mu.Lock()
+		if proc != s.current {
+			// A reload replaced this generation; keep supervising its successor.
+			s.mu.Unlock()
+			continue
+		}
+		stopping := s.stopping
+		if !stopping {
+			processExits.WithLabelValues(s.Name(), strconv.Itoa(exitCode(proc.err))).Inc()
+		}
+		if stopping || !policy.shouldRestart(proc.failed()) {
+			s.mu.Unlock()
+			s.setStopped()
+			return s.exitResult(proc, stopping)
+		}
+		// Keep the exited generation as current so Stop and WaitReady see a
+		// finished process while the restart is pending.
+		s.state = stateStarting
+		slot := s.slot
+		s.mu.Unlock()
+
+		next, err := s.restart(policy, proc, slot)
+		if err != nil || next == nil {
+			// gave up, or stopped while waiting to restart
+			s.setStopped()
+			return err
+		}
 	}
+}
 
-	logger.Info("upstream process started",
-		logger.String("command", command),
-		logger.Any("args", args),
-		logger.Int("pid", cmd.Process.Pid),
-		logger.String("working_dir", cmd.Dir))
+func (s *Server) setStopped() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	if err := cmd.Wait(); err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			exitCode := exitErr.ExitCode()
+	s.current = nil
+	s.state = stateStopped
+}
 
-			s.mu.Lock()
-			stopping := s.stopping
-			s.mu.Unlock()
+func (s *Server) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-			if stopping {
-				logger.Info("upstream process exited",
-					logger.Int("pid", cmd.Process.Pid),
-					logger.Int("exit_code", exitCode))
-				return nil
-			}
+	return s.stopping
+}
 
-			return fmt.Errorf("upstream process exited with code %d", exitCode)
-		}
+// runPostStopHooks runs once the process is gone for good. Failures are only
+// logged; the process has already stopped.
+func (s *Server) runPostStopHooks() {
+	if len(s.cfg.Hooks.PostStop) == 0 {
+		return
+	}
+	if err := s.runHooks(context.Background(), hookPostStop, s.cfg.Hooks.PostStop); err != nil {
+		logger.Error("upstream post-stop hook failed", logger.String("name", s.Name()), logger.Err(err))
+	}
+}
 
-		return fmt.Errorf("wait upstream command: %w", err)
+// restart waits out the backoff for an exited generation and spawns its
+// replacement on the same address. It returns nil without error when Stop was
+// called in the meantime.
+func (s *Server) restart(policy *restartPolicy, exited *process, slot int) (*process, error) {
+	code := exitCode(exited.err)
+
+	delay, err := policy.next(time.Now(), time.Since(exited.started))
+	if err != nil {
+		logger.Error("upstream process keeps exiting; giving up",
+			logger.String("name", s.Name()),
+			logger.Int("pid", exited.pid()),
+			logger.Int("exit_code", code),
+			logger.Err(err))
+		return nil, err
 	}
 
-	logger.Info("upstream process exited",
-		logger.Int("pid", cmd.Process.Pid),
-		logger.Int("exit_code", 0))
+	reason := "failure"
+	switch {
+	case exited.unready.Load():
+		reason = "unready"
+	case exited.err == nil:
+		reason = "exit"
+	}
+	processRestarts.WithLabelValues(s.Name(), reason).Inc()
+	logger.Warn("upstream process exited unexpectedly; restarting",
+		logger.String("name", s.Name()),
+		logger.Int("pid", exited.pid()),
+		logger.Int("exit_code", code),
+		logger.String("reason", reason),
+		logger.Int("attempt", policy.attempt),
+		logger.Duration("uptime", time.Since(exited.started)),
+		logger.Duration("backoff", delay))
+
+	// The exited process may have left children holding the socket.
+	s.reapGroup(exited.pid())
+
+	select {
+	case <-time.After(delay):
+	case <-s.stopCh:
+		return nil, nil
+	}
 
-	return nil
+	network, address := s.listenAddress(slot)
+	s.cleanupStale(network, address)
+	proc, err := s.spawn(network, address)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	if s.stopping {
+		s.mu.Unlock()
+		_ = s.stopProcess(proc)
+		return nil, nil
+	}
+	s.current = proc
+	s.mu.Unlock()
+
+	go s.watchAvailability(proc)
+	return proc, nil
 }
 
-// Stop attempts to gracefully stop the upstream process.
+// Stop attempts to gracefully stop the upstream process, including a generation
+// that an in-flight reload is still bringing up.
 func (s *Server) Stop() error {
 	s.mu.Lock()
-	cmd := s.cmd
-	done := s.done
+	proc, pending := s.current, s.pending
 	s.stopping = true
 	s.mu.Unlock()
+	s.stopOnce.Do(func() {
+		close(s.stopCh)
+	})
+
+	if pending != nil {
+		if err := s.stopProcess(pending); err != nil {
+			logger.Warn("failed to stop pending upstream process", logger.String("name", s.Name()), logger.Int("pid", pending.pid()), logger.Err(err))
+		}
+	}
 
-	if cmd == nil || cmd.Process == nil {
+	if proc == nil {
 		return nil
 	}
 
-	sig, err := parseSignal(s.cfg.StopSignal)
+	return s.stopProcess(proc)
+}
+
+// spawn starts a new generation of the command bound to the given address.
+func (s *Server) spawn(network, address string) (*process, error) {
+	command, args, err := normalizeCommand(s.cfg.Command, s.cfg.Args)
 	if err != nil {
-		logger.Warn("invalid stop signal; defaulting to SIGTERM", logger.String("signal", s.cfg.StopSignal), logger.Err(err))
-		sig = syscall.SIGTERM
+		return nil, fmt.Errorf("prepare upstream command: %w", err)
 	}
 
-	logger.Info("sending signal to upstream process",
-		logger.Int("pid", cmd.Process.Pid),
+	cmd := exec.Command(command, args...)
+	if s.cfg.Enabled && s.cfg.WorkingDirectory != "" {
+		if _, err := os.Stat(s.cfg.WorkingDirectory); err != nil {
+			if os.IsNotExist(err) {
+				return nil, fmt.Errorf("working directory does not exist: %s", s.cfg.WorkingDirectory)
+			}
+			return nil, fmt.Errorf("cannot access working directory %s: %w", s.cfg.WorkingDirectory, err)
+		}
+		cmd.Dir = s.cfg.WorkingDirectory
+	}
+
+	env, err := s.buildEnv(network, address)
+	if err != nil {
+		return nil, fmt.Errorf("prepare upstream environment: %w", err)
+	}
+	cmd.Env = env
+	cmd.SysProcAttr = newProcessGroupAttr()
+	steps := s.attrs.apply(cmd)
+	if s.cfg.SocketActivation && network != "" {
+		// systemd socket activation: the listener arrives as fd 3
+		file, err := s.listenerFile(network, address)
+		if err != nil {
+			return nil, err
+		}
+		cmd.ExtraFiles = []*os.File{file}
+		cmd.Env = append(cmd.Env, "LISTEN_FDS=1", "LISTEN_FDNAMES="+s.Name())
+		// LISTEN_PID must name the upstream itself, which only the child knows
+		steps = append([]string{"export LISTEN_PID=$$"}, steps...)
+	}
+	execThroughShell(cmd, steps)
+	output := s.newProcessOutput()
+	// the child runs in its own background process group, where reading the
+	// terminal would stop it with SIGTTIN; stdin stays unset (/dev/null)
+	cmd.Stdout = output.stdout
+	cmd.Stderr = output.stderr
+	// Captured output is copied through pipes that grandchildren may keep open.
+	cmd.WaitDelay = outputWaitDelay
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("start upstream command: %w", err)
+	}
+	output.setPID(cmd.Process.Pid)
+
+	proc := &process{
+		cmd:     cmd,
+		started: time.Now(),
+		network: network,
+		address: address,
+		done:    make(chan struct{}),
+		ready:   make(chan struct{}),
+	}
+
+	go func() {
+		proc.err = waitCommand(cmd)
+		output.flush()
+		close(proc.done)
+	}()
+
+	logger.Info("upstream process started",
+		logger.String("command", command),
+		logger.Any("args", args),
+		logger.String("name", s.Name()),
+		logger.Int("pid", proc.pid()),
+		logger.String("address", address),
+		logger.String("working_dir", cmd.Dir))
+
+	return proc, nil
+}
+
+// stopProcess signals the process group of a single generation, waits for the
+// leader to exit and makes sure none of its children are left behind.
+func (s *Server) stopProcess(proc *process) error {
+	sig, timeout := s.stopSignal(), s.stopTimeout()
+
+	logger.Info("sending signal to upstream process group",
+		logger.String("name", s.Name()),
+		logger.Int("pid", proc.pid()),
 		logger.String("signal", sig.String()))
 
-	if err := cmd.Process.Signal(sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
+	if err := signalGroup(proc.pid(), sig); err != nil && !errors.Is(err, os.ErrProcessDone) {
 		return fmt.Errorf("signal upstream process: %w", err)
 	}
 
-	if done != nil {
-		select {
-		case <-done:
-		case <-time.After(defaultStopTimeout):
-			logger.Warn("upstream process did not exit within timeout; killing",
-				logger.Int("pid", cmd.Process.Pid),
-				logger.Duration("timeout", defaultStopTimeout))
-			if killErr := cmd.Process.Kill(); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
-				return fmt.Errorf("kill upstream process: %w", killErr)
-			}
-			if done != nil {
-				<-done
-			}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-proc.done:
+	case <-timer.C:
+		logger.Warn("upstream process did not exit within timeout; killing",
+			logger.String("name", s.Name()),
+			logger.Int("pid", proc.pid()),
+			logger.Duration("timeout", timeout))
+		if killErr := signalGroup(proc.pid(), syscall.SIGKILL); killErr != nil && !errors.Is(killErr, os.ErrProcessDone) {
+			return fmt.Errorf("kill upstream process: %w", killErr)
 		}
+		<-proc.done
 	}
 
+	s.reapGroup(proc.pid())
 	return nil
 }
 
-// String implements app.IServer for logging purposes.
-func (s *Server) String() string {
-	return "upstream process supervisor"
+func (s *Server) stopSignal() syscall.Signal {
+	sig, err := ParseSignal(s.cfg.StopSignal)
+	if err != nil {
+		logger.Warn("invalid stop signal; defaulting to SIGTERM", logger.String("signal", s.cfg.StopSignal), logger.Err(err))
+		return syscall.SIGTERM
+	}
+	return sig
+}
+
+func (s *Server) stopTimeout() time.Duration {
+	if s.cfg.StopTimeout > 0 {
+		return time.Duration(s.cfg.StopTimeout) * time.Second
+	}
+	return defaultStopTimeout
 }
 
-func (s *Server) buildEnv() []string {
-	merged := map[string]string{}
+// exitResult converts the exit status of the final generation into Start's result.
+func (s *Server) exitResult(proc *process, stopping bool) error {
+	if proc.unready.Load() && !stopping {
+		return errNotReady
+	}
+	if err := proc.err; err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			exitCode := exitErr.ExitCode()
 
-	for _, kv := range os.Environ() {
-		if idx := strings.Index(kv, "="); idx != -1 {
-			merged[kv[:idx]] = kv[idx+1:]
+			if stopping {
+				logger.Info("upstream process exited",
+					logger.String("name", s.Name()),
+					logger.Int("pid", proc.pid()),
+					logger.Int("exit_code", exitCode))
+				return nil
+			}
+
+			return fmt.Errorf("upstream %s exited with code %d", s.Name(), exitCode)
 		}
-	}
 
-	// Only export PORT when not using a UNIX socket binding to avoid conflicts.
-	if s.cfg.TargetBindSocket == "" && s.cfg.TargetPort > 0 {
-		merged["PORT"] = strconv.Itoa(s.cfg.TargetPort)
+		return fmt.Errorf("wait upstream command: %w", err)
 	}
 
-	for key, value := range s.cfg.Env {
-		merged[key] = value
+	logger.Info("upstream process exited",
+		logger.String("name", s.Name()),
+		logger.Int("pid", proc.pid()),
+		logger.Int("exit_code", 0))
+
+	return nil
+}
+
+// String implements app.IServer for logging purposes.
+func (s *Server) String() string {
+	return "upstream process supervisor: " + s.Name()
+}
+
+// Name identifies the upstream in logs, metrics and admin endpoints.
+func (s *Server) Name() string {
+	if s.cfg.Name == "" {
+		return defaultName
 	}
+	return s.cfg.Name
+}
+
+// Proxy reports whether the reverse proxy sends traffic to this upstream.
+func (s *Server) Proxy() bool {
+	return s.cfg.Proxy
+}
+
+// Status is a point-in-time view of a supervised upstream for admin endpoints.
+type Status struct {
+	Name    string `json:"name"`
+	Proxy   bool   `json:"proxy"`
+	State   string `json:"state"`
+	PID     int    `json:"pid,omitempty"`
+	Address string `json:"address,omitempty"`
+}
+
+// Status returns the current state of the supervised process.
+func (s *Server) Status() Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	env := make([]string, 0, len(merged))
-	for key, value := range merged {
-		env = append(env, fmt.Sprintf("%s=%s", key, value))
+	status := Status{Name: s.Name(), Proxy: s.cfg.Proxy, State: stateNames[s.state]}
+	if s.current != nil {
+		status.PID = s.current.pid()
+		status.Address = s.current.address
 	}
+	return status
+}
+
+// Address returns where the current generation accepts connections, or the
+// configured address before the first start. network is empty when the
+// upstream does not listen.
+func (s *Server) Address() (network, address string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 
-	return env
+	if s.current != nil {
+		return s.current.network, s.current.address
+	}
+	return s.listenAddress(s.slot)
 }
 
 func normalizeCommand(command string, extraArgs []string) (string, []string, error) {
@@ -259,22 +567,3 @@ func splitCommandLine(command string) ([]string, error) {
 
 	return result, nil
 }
-
-func parseSignal(name string) (syscall.Signal, error) {
-	if name == "" {
-		return syscall.SIGTERM, nil
-	}
-
-	switch strings.ToUpper(name) {
-	case "SIGTERM", "TERM":
-		return syscall.SIGTERM, nil
-	case "SIGINT", "INT":
-		return syscall.SIGINT, nil
-	case "SIGQUIT", "QUIT":
-		return syscall.SIGQUIT, nil
-	case "SIGKILL", "KILL":
-		return syscall.SIGKILL, nil
-	}
-
-	return 0, fmt.Errorf("unsupported signal %q", name)
-}
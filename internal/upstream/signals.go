@@ -0,0 +1,86 @@
+package upstream
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/go-dev-frame/sponge/pkg/logger"
+)
+
+// ParseSignal accepts any signal name known to the platform, with or without the
+// SIG prefix ("USR1", "sigttin", "SIGWINCH"), or a signal number. An empty name
+// selects SIGTERM.
+func ParseSignal(name string) (syscall.Signal, error) {
+	name = strings.ToUpper(strings.TrimSpace(name))
+	if name == "" {
+		return syscall.SIGTERM, nil
+	}
+
+	if n, err := strconv.Atoi(name); err == nil {
+		if n <= 0 || unix.SignalName(syscall.Signal(n)) == "" {
+			return 0, fmt.Errorf("unsupported signal %q", name)
+		}
+		return syscall.Signal(n), nil
+	}
+
+	if !strings.HasPrefix(name, "SIG") {
+		name = "SIG" + name
+	}
+	if sig := unix.SignalNum(name); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unsupported signal %q", name)
+}
+
+// RelaySignals returns the signals the parent process should forward to the
+// upstream's process group. Signals that stop the supervisor itself or cannot be
+// caught are never relayed.
+func (s *Server) RelaySignals() []os.Signal {
+	signals := make([]os.Signal, 0, len(s.cfg.RelaySignals))
+	for _, name := range s.cfg.RelaySignals {
+		sig, err := ParseSignal(name)
+		if err == nil && !relayable(sig) {
+			err = fmt.Errorf("signal %s cannot be relayed", unix.SignalName(sig))
+		}
+		if err != nil {
+			logger.Warn("ignoring invalid upstream relay signal", logger.String("name", s.Name()), logger.String("signal", name), logger.Err(err))
+			continue
+		}
+		signals = append(signals, sig)
+	}
+	return signals
+}
+
+// Signal delivers sig to the process group of the current generation.
+func (s *Server) Signal(sig syscall.Signal) error {
+	s.mu.Lock()
+	proc := s.current
+	s.mu.Unlock()
+
+	if proc == nil {
+		return errNotRunning
+	}
+
+	logger.Info("sending signal to upstream process group",
+		logger.String("name", s.Name()),
+		logger.Int("pid", proc.pid()),
+		logger.String("signal", unix.SignalName(sig)))
+
+	if err := signalGroup(proc.pid(), sig); err != nil {
+		return fmt.Errorf("signal upstream %s: %w", s.Name(), err)
+	}
+	return nil
+}
+
+func relayable(sig syscall.Signal) bool {
+	switch sig {
+	case syscall.SIGINT, syscall.SIGTERM, syscall.SIGKILL, syscall.SIGSTOP:
+		return false
+	}
+	return true
+}
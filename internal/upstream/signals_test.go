@@ -0,0 +1,61 @@
+package upstream
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"thrust_oauth2id/internal/config"
+)
+
+func TestParseSignal(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{name: "", want: syscall.SIGTERM},
+		{name: "SIGTERM", want: syscall.SIGTERM},
+		{name: "usr1", want: syscall.SIGUSR1},
+		{name: "SIGUSR2", want: syscall.SIGUSR2},
+		{name: "TTIN", want: syscall.SIGTTIN},
+		{name: "sigttou", want: syscall.SIGTTOU},
+		{name: " WINCH ", want: syscall.SIGWINCH},
+		{name: "9", want: syscall.SIGKILL},
+		{name: "SIGBOGUS", wantErr: true},
+		{name: "0", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignal(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSignal(%q) error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("ParseSignal(%q) = %v, want %v", tt.name, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRelaySignalsSkipsShutdownSignals(t *testing.T) {
+	t.Parallel()
+
+	s := NewServer(config.Upstream{RelaySignals: []string{"USR1", "TERM", "TTIN", "KILL", "nope"}})
+	got := s.RelaySignals()
+	want := []os.Signal{syscall.SIGUSR1, syscall.SIGTTIN}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Fatalf("RelaySignals() = %v, want %v", got, want)
+	}
+}
+
+func TestSignalWithoutProcess(t *testing.T) {
+	t.Parallel()
+
+	if err := NewServer(config.Upstream{}).Signal(syscall.SIGUSR1); err != errNotRunning {
+		t.Fatalf("Signal() error = %v, want %v", err, errNotRunning)
+	}
+}